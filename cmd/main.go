@@ -7,8 +7,10 @@
 	"os"
 
 	"github.com/rancher/wins/cmd/stackdump"
+	"github.com/rancher/wins/cmd/svcwrap"
 
 	"github.com/mattn/go-colorable"
+	"github.com/rancher/wins/cmd/plan"
 	"github.com/rancher/wins/cmd/server"
 	"github.com/rancher/wins/pkg/defaults"
 	"github.com/rancher/wins/pkg/panics"
@@ -63,7 +65,9 @@ func main() {
 
 	app.Commands = []*cli.Command{
 		server.NewCommand(),
+		plan.NewCommand(),
 		stackdump.NewCommand(),
+		svcwrap.NewCommand(),
 	}
 
 	app.Flags = []cli.Flag{
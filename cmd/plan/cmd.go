@@ -0,0 +1,15 @@
+package plan
+
+import (
+	"github.com/urfave/cli/v2"
+)
+
+func NewCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "plan",
+		Usage: "Manage local system-agent plans",
+		Subcommands: []*cli.Command{
+			signCommand(),
+		},
+	}
+}
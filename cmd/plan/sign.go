@@ -0,0 +1,63 @@
+package plan
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/wins/pkg/signatures"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var _signFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:     "key",
+		Usage:    "[required] Specifies the path of the PEM encoded ed25519 or ECDSA private key",
+		Required: true,
+	},
+}
+
+func _signAction(cliCtx *cli.Context) error {
+	if cliCtx.NArg() == 0 {
+		return errors.New("at least one plan file must be provided")
+	}
+
+	keyPath := cliCtx.String("key")
+	keyData, err := os.ReadFile(keyPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read private key from %s", keyPath)
+	}
+	key, err := signatures.ParsePrivateKey(keyData)
+	if err != nil {
+		return errors.Wrapf(err, "failed to load private key from %s", keyPath)
+	}
+
+	for _, planPath := range cliCtx.Args().Slice() {
+		content, err := os.ReadFile(planPath)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read plan %s", planPath)
+		}
+
+		sig, err := signatures.Sign(key, content)
+		if err != nil {
+			return errors.Wrapf(err, "failed to sign plan %s", planPath)
+		}
+
+		sigPath := planPath + signatures.Suffix
+		if err := os.WriteFile(sigPath, sig, os.ModePerm); err != nil {
+			return errors.Wrapf(err, "failed to write signature %s", sigPath)
+		}
+		logrus.Infof("Signed %s, signature written to %s", planPath, sigPath)
+	}
+	return nil
+}
+
+func signCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "sign",
+		Usage:     "Create detached signatures for local plans",
+		ArgsUsage: "<plan file>...",
+		Flags:     _signFlags,
+		Action:    _signAction,
+	}
+}
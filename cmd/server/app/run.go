@@ -5,10 +5,14 @@
 
 	"github.com/pkg/errors"
 	"github.com/rancher/wins/cmd/server/config"
-	"github.com/rancher/wins/pkg/csiproxy"
+	"github.com/rancher/wins/pkg/components"
 	"github.com/rancher/wins/pkg/defaults"
+	"github.com/rancher/wins/pkg/logs"
+	"github.com/rancher/wins/pkg/metrics"
 	"github.com/rancher/wins/pkg/panics"
 	"github.com/rancher/wins/pkg/profilings"
+	"github.com/rancher/wins/pkg/scm"
+	"github.com/rancher/wins/pkg/services"
 	"github.com/rancher/wins/pkg/systemagent"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
@@ -90,22 +94,40 @@ func _runAction(cliCtx *cli.Context) error {
 		return errors.Wrapf(err, "failed to load config from %s", cfgPath)
 	}
 
+	// the log file is written in addition to the event log and ETW, starting with the installation of the components
+	if logFile := cfg.LogFileOf(defaults.WindowsServiceName); logFile != nil {
+		hook, err := logs.NewFileHook(*logFile)
+		if err != nil {
+			return errors.Wrap(err, "could not create log file logrus hook")
+		}
+		defer hook.Close()
+		logrus.AddHook(hook)
+	}
+
 	// adding system agent
 	agent := systemagent.New(cfg.SystemAgent)
 
 	// Determine if the agent should use strict verification
 	agent.StrictTLSMode = cfg.AgentStrictTLSMode
 
-	//checking if CSI Proxy has config, if so enables it.
-	if cfg.CSIProxy != nil {
-		logrus.Infof("CSI Proxy will be enabled as a Windows service.")
-		csi, err := csiproxy.New(cfg.CSIProxy, cfg.TLSConfig)
-		if err != nil {
-			return err
-		}
-		if err := csi.Enable(); err != nil {
-			return err
-		}
+	if cfg.Metrics != nil {
+		go metrics.Serve(ctx, cfg.Metrics)
+	}
+
+	// installing the configured components and CSI Proxy, and removing the ones wins installed that are not configured anymore.
+	// Components that fail are retried by their monitor, they do not prevent wins from starting.
+	cfgs, err := cfg.ManagedComponents()
+	if err != nil {
+		return err
+	}
+	if err := components.Reconcile(ctx, scm.System(), cfgs, cfg.InstallRoot, cfg.LogDir, cfg.TLSConfig); err != nil {
+		return err
+	}
+
+	// the dependencies are configured after the components, so that the dependencies on their services can be added.
+	// A failure does not stop wins, as the services keep their previous dependencies.
+	if err := services.ReconcileDependencies(scm.System(), defaults.WindowsServiceName, cfg.ServiceDependencies); err != nil {
+		logrus.Errorf("Failed to configure the service dependencies: %v", err)
 	}
 
 	if cfg.Debug {
@@ -6,22 +6,24 @@
 	"os"
 	"strings"
 	"time"
-	"unsafe"
 
 	"github.com/pkg/errors"
 	"github.com/rancher/wins/pkg/defaults"
 	"github.com/rancher/wins/pkg/logs"
 	"github.com/rancher/wins/pkg/paths"
 	"github.com/rancher/wins/pkg/profilings"
+	"github.com/rancher/wins/pkg/scm"
+	"github.com/rancher/wins/pkg/services"
 	"github.com/rancher/wins/pkg/systemagent"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/sys/windows"
 	"golang.org/x/sys/windows/svc"
 	"golang.org/x/sys/windows/svc/debug"
 	"golang.org/x/sys/windows/svc/eventlog"
-	"golang.org/x/sys/windows/svc/mgr"
 )
 
+// serviceRemovalTimeout is the time the service has to stop and be removed when it is registered again or unregistered.
+const serviceRemovalTimeout = 2 * time.Minute
+
 func registerService(delayedStart bool) error {
 	// confirm wins binary path
 	binaryPath, err := paths.GetBinaryPath(os.Args[0])
@@ -29,40 +31,19 @@ func registerService(delayedStart bool) error {
 		return errors.Wrap(err, "could not get binary")
 	}
 
-	// open SCM
-	m, err := mgr.Connect()
+	manager := scm.System()
+
+	// a registered service is replaced, its event log source is kept as it is installed again below and the
+	// dependency records are restored on the new service, as they are lost with the service key
+	values, err := services.RecordedValues(manager, defaults.WindowsServiceName)
 	if err != nil {
-		return errors.Wrap(err, "could not open SCM")
+		return errors.Wrap(err, "could not read the dependency records of the registered service")
 	}
-	defer m.Disconnect()
-
-	// if the service can be opened that means it was registered
-	w, err := m.OpenService(defaults.WindowsServiceName)
-	if err == nil {
-		status, err := w.Query()
-		for err == nil {
-			if status.State == svc.Stopped {
-				break
-			}
-
-			logrus.Warnf("Service is not stopped, going to stop it")
-			status, err = w.Control(svc.Stop)
-			time.Sleep(3 * time.Second)
-		}
-		if err != nil {
-			return errors.Wrap(err, "could not query status")
-		}
-
-		// drop this service
-		err = w.Delete()
-		if err != nil {
-			w.Close()
-			return errors.Wrap(err, "could not delete")
-		}
-		w.Close()
-
-		// wait a while
-		time.Sleep(3 * time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), serviceRemovalTimeout)
+	defer cancel()
+	report := services.Remove(ctx, manager, defaults.WindowsServiceName, services.Cleanup{})
+	if err := report.Err(); err != nil {
+		return errors.Wrap(err, "could not remove the registered service")
 	}
 
 	// join server run arguments
@@ -76,16 +57,15 @@ func registerService(delayedStart bool) error {
 	}
 
 	// create a new service inst
-	w, err = m.CreateService(
+	w, err := manager.CreateService(
 		defaults.WindowsServiceName,
 		binaryPath,
-		mgr.Config{
-			ServiceType:      windows.SERVICE_WIN32_OWN_PROCESS,
-			StartType:        mgr.StartAutomatic,
-			ErrorControl:     mgr.ErrorNormal,
+		scm.Config{
+			ServiceType:      scm.ServiceWin32OwnProcess,
+			StartType:        scm.StartAutomatic,
+			ErrorControl:     scm.ErrorNormal,
 			DisplayName:      defaults.WindowsServiceDisplayName,
 			DelayedAutoStart: delayedStart,
-			BinaryPathName:   binaryPath,
 		},
 		args...,
 	)
@@ -94,37 +74,19 @@ func registerService(delayedStart bool) error {
 	}
 	defer w.Close()
 
-	// see http://stackoverflow.com/questions/35151052/how-do-i-configure-failure-actions-of-a-windows-service-written-in-go
-	// using failure action to control the restart after upgrading
-	const (
-		scActionNone    = 0
-		scActionRestart = 1
-
-		serviceConfigFailureActions = 2
-	)
-	// serviceFailureActions correspond to https://docs.microsoft.com/en-us/windows/win32/api/winsvc/ns-winsvc-service_failure_actionsa
-	type serviceFailureActions struct {
-		ResetPeriod  uint32
-		RebootMsg    *uint16
-		Command      *uint16
-		ActionsCount uint32
-		Actions      uintptr
-	}
-	// scAction corresponds to https://docs.microsoft.com/en-us/windows/win32/api/winsvc/ns-winsvc-sc_action
-	type scAction struct {
-		Type  uint32
-		Delay uint32
+	if err := services.RestoreRecordedValues(w, values); err != nil {
+		return errors.Wrap(err, "could not restore the dependency records")
 	}
+
+	// using failure action to control the restart after upgrading
 	// Defines that wins should try to restart the service after 5s, 10s, and 15s. If it still fails, wins does not try to restart the service anymore
-	t := []scAction{
-		{Type: scActionRestart, Delay: uint32(5 * time.Second / time.Millisecond)},
-		{Type: scActionRestart, Delay: uint32(10 * time.Second / time.Millisecond)},
-		{Type: scActionRestart, Delay: uint32(15 * time.Second / time.Millisecond)},
-		{Type: scActionNone},
+	actions := []scm.RecoveryAction{
+		{Type: scm.ServiceRestart, Delay: 5 * time.Second},
+		{Type: scm.ServiceRestart, Delay: 10 * time.Second},
+		{Type: scm.ServiceRestart, Delay: 15 * time.Second},
+		{Type: scm.NoAction},
 	}
-	lpInfo := serviceFailureActions{ResetPeriod: uint32(5 * time.Minute / time.Second), ActionsCount: uint32(len(t)), Actions: uintptr(unsafe.Pointer(&t[0]))}
-	// Arguments provided adhere to https://docs.microsoft.com/en-us/windows/win32/api/winsvc/nf-winsvc-changeserviceconfig2w
-	err = windows.ChangeServiceConfig2(w.Handle, serviceConfigFailureActions, (*byte)(unsafe.Pointer(&lpInfo)))
+	err = w.SetRecoveryActions(actions, uint32(5*time.Minute/time.Second))
 	if err != nil {
 		return errors.Wrap(err, "could not add failure action")
 	}
@@ -142,27 +104,17 @@ type scAction struct {
 }
 
 func unregisterService() error {
-	// open SCM
-	m, err := mgr.Connect()
-	if err != nil {
-		return errors.Wrap(err, "could not open SCM")
-	}
-	defer m.Disconnect()
+	ctx, cancel := context.WithTimeout(context.Background(), serviceRemovalTimeout)
+	defer cancel()
 
-	w, err := m.OpenService(defaults.WindowsServiceName)
-	if err != nil {
-		return errors.Wrap(err, "service hasn't been registered")
+	report := services.Remove(ctx, scm.System(), defaults.WindowsServiceName, services.Cleanup{EventSource: defaults.WindowsServiceName})
+	logrus.Info(report)
+	if err := report.Err(); err != nil {
+		return err
 	}
-	defer w.Close()
-
-	// if the service can be opened that means it was registered
-	eventlog.Remove(defaults.WindowsServiceName)
-
-	err = w.Delete()
-	if err != nil {
-		return errors.Wrap(err, "could not delete")
+	if len(report.Removed) == 0 {
+		return errors.New("service hasn't been registered")
 	}
-
 	return nil
 }
 
@@ -177,7 +129,8 @@ func runService(ctx context.Context, agent *systemagent.Agent) error {
 	}
 	if isWindowsService {
 		// If we can detect that this is a Windows service that is already running, execute it as a Service instead
-		// after configuring logrus to print logs to Event Tracing for Windows (ETW) and the service's Event Log
+		// after configuring logrus to print logs to Event Tracing for Windows (ETW) and the service's Event Log, the
+		// log file configured in the config is written by a hook as well
 
 		run = svc.Run
 
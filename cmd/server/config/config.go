@@ -3,10 +3,16 @@
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/pkg/errors"
-	"github.com/rancher/system-agent/pkg/config"
+	"github.com/rancher/wins/pkg/components"
 	"github.com/rancher/wins/pkg/csiproxy"
+	"github.com/rancher/wins/pkg/defaults"
+	"github.com/rancher/wins/pkg/logs"
+	"github.com/rancher/wins/pkg/metrics"
+	"github.com/rancher/wins/pkg/services"
+	"github.com/rancher/wins/pkg/systemagent"
 	wintls "github.com/rancher/wins/pkg/tls"
 	"sigs.k8s.io/yaml"
 )
@@ -14,21 +20,97 @@
 func DefaultConfig() *Config {
 	return &Config{
 		AgentStrictTLSMode: false,
+		InstallRoot:        defaults.InstallRoot,
+		LogDir:             defaults.LogDir,
 	}
 }
 
 type Config struct {
 	Debug              bool                `yaml:"debug" json:"debug"`
-	SystemAgent        *config.AgentConfig `yaml:"systemagent" json:"systemagent,omitempty"`
+	SystemAgent        *systemagent.Config `yaml:"systemagent" json:"systemagent,omitempty"`
 	AgentStrictTLSMode bool                `yaml:"agentStrictTLSMode" json:"agentStrictTLSMode"`
 	CSIProxy           *csiproxy.Config    `yaml:"csi-proxy" json:"csi-proxy,omitempty"`
-	TLSConfig          *wintls.Config      `yaml:"tls-config" json:"tls-config,omitempty"`
+	Components         []components.Config `yaml:"components" json:"components,omitempty"`
+	// InstallRoot is the directory CSI Proxy and the components are installed into.
+	InstallRoot string `yaml:"installRoot" json:"installRoot,omitempty"`
+	// LogDir is the directory the captured output of components is written to.
+	LogDir string `yaml:"logDir" json:"logDir,omitempty"`
+	// LogFile configures the log file of wins and the SUC, which are only written if it is set.
+	LogFile *logs.FileConfig `yaml:"logFile" json:"logFile,omitempty"`
+	// ServiceDependencies are the dependencies between Windows services that wins and the SUC configure.
+	ServiceDependencies []services.Dependency `yaml:"serviceDependencies" json:"serviceDependencies,omitempty"`
+	TLSConfig           *wintls.Config        `yaml:"tls-config" json:"tls-config,omitempty"`
+	Metrics             *metrics.Config       `yaml:"metrics" json:"metrics,omitempty"`
 }
 
 func (c *Config) Validate() error {
+	if c.SystemAgent != nil {
+		if err := c.SystemAgent.Validate(); err != nil {
+			return errors.Wrap(err, "invalid systemagent config")
+		}
+	}
+	if c.Metrics != nil {
+		if err := c.Metrics.Validate(); err != nil {
+			return errors.Wrap(err, "invalid metrics config")
+		}
+	}
+	if c.InstallRoot == "" || !filepath.IsAbs(c.InstallRoot) {
+		return fmt.Errorf("installRoot %q must be an absolute path", c.InstallRoot)
+	}
+	if c.LogDir == "" || !filepath.IsAbs(c.LogDir) {
+		return fmt.Errorf("logDir %q must be an absolute path", c.LogDir)
+	}
+	if c.LogFile != nil {
+		if err := c.LogFile.Validate(); err != nil {
+			return errors.Wrap(err, "invalid logFile config")
+		}
+	}
+	if _, err := c.ManagedComponents(); err != nil {
+		return errors.Wrap(err, "invalid components config")
+	}
+	if err := services.ValidateDependencies(c.ServiceDependencies); err != nil {
+		return errors.Wrap(err, "invalid serviceDependencies config")
+	}
 	return nil
 }
 
+// LogFileOf returns the log file configuration of the named binary, nil if no log file is configured. Wins writes to
+// the configured path, which defaults to <name>.log in the LogDir, other binaries write to <name>.log next to it, as a
+// log file cannot be rotated safely by more than one process.
+func (c *Config) LogFileOf(name string) *logs.FileConfig {
+	if c.LogFile == nil {
+		return nil
+	}
+	f := *c.LogFile
+	switch {
+	case f.Path == "":
+		f.Path = filepath.Join(c.LogDir, name+".log")
+	case name != defaults.WindowsServiceName:
+		f.Path = filepath.Join(filepath.Dir(f.Path), name+".log")
+	}
+	return &f
+}
+
+// ManagedComponents returns the components wins manages, CSI Proxy followed by the configured components.
+func (c *Config) ManagedComponents() ([]components.Config, error) {
+	csi, err := c.CSIProxy.Component()
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid csi-proxy config")
+	}
+
+	for _, component := range c.Components {
+		if component.Name == csiproxy.ComponentName {
+			return nil, fmt.Errorf("component name %s is reserved for the csi-proxy section", csiproxy.ComponentName)
+		}
+	}
+
+	cfgs := append([]components.Config{*csi}, c.Components...)
+	if err := components.ValidateAll(cfgs); err != nil {
+		return nil, err
+	}
+	return cfgs, nil
+}
+
 func LoadConfig(path string, v *Config) error {
 	if v == nil {
 		return errors.New("config cannot be nil")
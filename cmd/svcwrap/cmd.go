@@ -0,0 +1,17 @@
+package svcwrap
+
+import (
+	"github.com/rancher/wins/pkg/logs"
+	"github.com/urfave/cli/v2"
+)
+
+func NewCommand() *cli.Command {
+	return &cli.Command{
+		Name:            logs.CaptureCommand,
+		Usage:           "Run an executable as a Windows service and capture its output into rotated log files",
+		UsageText:       logs.CaptureCommand + " --log-file <path> [--max-size <MB>] [--max-age <days>] [--max-backups <count>] [--compress] -- <exe> [args...]",
+		Hidden:          true,
+		SkipFlagParsing: true,
+		Action:          _svcWrapAction,
+	}
+}
@@ -0,0 +1,147 @@
+package svcwrap
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/rancher/wins/pkg/logs"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/debug"
+)
+
+const (
+	// exitStartFailed is the exit code of the service if the executable cannot be started.
+	exitStartFailed = 1
+	// stopTimeout is how long the executable has to exit after it was interrupted before it is terminated.
+	stopTimeout = 15 * time.Second
+)
+
+var procAllocConsole = windows.NewLazySystemDLL("kernel32.dll").NewProc("AllocConsole")
+
+func _svcWrapAction(cliCtx *cli.Context) error {
+	cfg, command, err := logs.ParseCaptureArgs(cliCtx.Args().Slice())
+	if err != nil {
+		return cli.Exit(err.Error(), exitStartFailed)
+	}
+
+	out := logs.NewRotatingFile(cfg.File, cfg.RotationConfig)
+	defer out.Close()
+	logrus.SetFormatter(&logrus.TextFormatter{DisableColors: true, FullTimestamp: true})
+	logrus.SetOutput(out)
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	// the executable gets its own process group, so that it can be interrupted without interrupting the wrapper
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: windows.CREATE_NEW_PROCESS_GROUP}
+
+	run := debug.Run
+	isWindowsService, err := svc.IsWindowsService()
+	if err != nil {
+		return err
+	}
+	if isWindowsService {
+		run = svc.Run
+		// services have no console, the executable shares the one of the wrapper so that console control
+		// events can be sent to it
+		if ok, _, err := procAllocConsole.Call(); ok == 0 {
+			logrus.Warnf("Failed to allocate a console, %s is terminated without being interrupted when the service stops: %v", command[0], err)
+		}
+	}
+
+	w := &wrapper{cmd: cmd, out: out}
+	if err := run(logs.CaptureCommand, w); err != nil {
+		return err
+	}
+	if w.exitCode != 0 {
+		return cli.Exit("", int(w.exitCode))
+	}
+	return nil
+}
+
+// wrapper runs the executable for as long as the service runs, and stops the service when the executable exits.
+type wrapper struct {
+	cmd      *exec.Cmd
+	out      io.Closer
+	exitCode uint32
+}
+
+func (w *wrapper) Execute(_ []string, r <-chan svc.ChangeRequest, s chan<- svc.Status) (bool, uint32) {
+	s <- svc.Status{State: svc.StartPending, Accepts: 0}
+
+	logrus.Infof("Starting %s", w.cmd)
+	if err := w.cmd.Start(); err != nil {
+		logrus.Errorf("Failed to start %s: %v", w.cmd.Path, err)
+		w.exitCode = exitStartFailed
+		return false, w.exitCode
+	}
+	doneC := make(chan error, 1)
+	go func() {
+		doneC <- w.cmd.Wait()
+	}()
+
+	s <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case err := <-doneC:
+			code := exitCode(err)
+			if code == 0 {
+				logrus.Warnf("%s exited, stopping the service", w.cmd.Path)
+				return false, 0
+			}
+			// terminate without reporting the service as stopped, so that the service manager treats the exit
+			// as a failure of the service and applies its recovery actions
+			logrus.Errorf("%s exited with code %d", w.cmd.Path, code)
+			_ = w.out.Close()
+			os.Exit(code)
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				s <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				s <- svc.Status{State: svc.StopPending, Accepts: 0, WaitHint: uint32((stopTimeout + time.Second) / time.Millisecond)}
+				w.stop(doneC)
+				return false, 0
+			}
+		}
+	}
+}
+
+// stop interrupts the executable with a CTRL_BREAK_EVENT and waits for it to exit. It is terminated if it
+// cannot be interrupted or does not exit within stopTimeout.
+func (w *wrapper) stop(doneC <-chan error) {
+	logrus.Infof("Stopping %s", w.cmd.Path)
+	if err := windows.GenerateConsoleCtrlEvent(windows.CTRL_BREAK_EVENT, uint32(w.cmd.Process.Pid)); err != nil {
+		logrus.Warnf("Failed to interrupt %s, terminating it: %v", w.cmd.Path, err)
+	} else {
+		select {
+		case <-doneC:
+			return
+		case <-time.After(stopTimeout):
+			logrus.Warnf("%s did not exit within %s after it was interrupted, terminating it", w.cmd.Path, stopTimeout)
+		}
+	}
+	if err := w.cmd.Process.Kill(); err != nil {
+		logrus.Errorf("Failed to stop %s: %v", w.cmd.Path, err)
+	}
+	<-doneC
+}
+
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		return exitStartFailed
+	}
+	return 0
+}
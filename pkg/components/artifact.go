@@ -0,0 +1,189 @@
+package components
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	neturl "net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/wins/pkg/download"
+	"github.com/sirupsen/logrus"
+)
+
+// download retrieves the executable of the component from its artifact into dest. The archive and the
+// executable are verified against the configured checksums, and nothing is left at dest if any check fails.
+func (c *Component) download(dest string) error {
+	tlsConfig, err := c.tlsCfg.ClientConfig()
+	if err != nil {
+		return err
+	}
+
+	client := download.NewClient(tlsConfig)
+	defer client.HTTP.CloseIdleConnections()
+	ctx := context.Background()
+
+	url := fmt.Sprintf(c.cfg.URL, c.cfg.Version)
+	archiveSum := c.cfg.ArchiveSHA256
+	if c.cfg.ChecksumsURL != "" {
+		sum, err := c.fetchArchiveSum(ctx, client, fileName(url))
+		if err != nil {
+			return err
+		}
+		archiveSum = sum
+	}
+	if archiveSum == "" && c.cfg.BinarySHA256 == "" {
+		logrus.Warnf("No checksum is configured for %s, the download from %s is not verified", c.cfg.Name, url)
+	}
+
+	var sig *download.Signature
+	if c.cfg.Signature != nil {
+		sigURL := fmt.Sprintf(c.cfg.Signature.SignatureURL(c.cfg.URL), c.cfg.Version)
+		if sig, err = client.FetchSignature(ctx, c.cfg.Signature, c.cached(sigURL)); err != nil {
+			return errors.Wrapf(err, "failed to download the %s signature", c.cfg.Name)
+		}
+	}
+
+	err = client.FetchContent(ctx, c.cached(url), func(body io.Reader, contentType string) error {
+		return download.Stage(dest, func(f *os.File) error {
+			return c.extract(body, fileName(url), contentType, f, archiveSum, sig)
+		})
+	})
+	return errors.Wrapf(err, "failed to download %s", c.cfg.Name)
+}
+
+// extract verifies the artifact read from body and writes the executable it contains to file.
+// Without a valid signature, if one is required, nothing is written.
+func (c *Component) extract(body io.Reader, name, contentType string, file *os.File, archiveSum string, sig *download.Signature) error {
+	// the artifact is kept in a temporary file, as zip archives cannot be read as a stream
+	artifact, err := os.CreateTemp("", c.cfg.Name+"-artifact-*")
+	if err != nil {
+		return err
+	}
+	defer func(artifact *os.File) {
+		_ = artifact.Close()
+		_ = os.Remove(artifact.Name())
+	}(artifact)
+
+	archiveHash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(artifact, archiveHash), body); err != nil {
+		return err
+	}
+	if err := verifySum("the "+c.cfg.Name+" archive", archiveSum, archiveHash); err != nil {
+		return download.Permanent(err)
+	}
+	if sig != nil {
+		if _, err := artifact.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := sig.Verify(artifact); err != nil {
+			logrus.Errorf("The signature of the %s artifact %s is not valid: %v", c.cfg.Name, name, err)
+			return download.Permanent(errors.Wrap(err, "invalid signature"))
+		}
+		logrus.Infof("The signature of the %s artifact %s is valid", c.cfg.Name, name)
+	}
+
+	format := c.format
+	if format == "" {
+		if format, err = download.DetectFormat(artifact, name, contentType); err != nil {
+			return download.Permanent(err)
+		}
+	}
+	logrus.Debugf("Extracting %s from the %s artifact in %s format", c.archivePath(), c.cfg.Name, format)
+
+	binaryHash := sha256.New()
+	if err := download.ExtractFile(artifact, format, c.archivePath(), io.MultiWriter(file, binaryHash)); err != nil {
+		return download.Permanent(err)
+	}
+	return download.Permanent(verifySum(c.cfg.binary(), c.cfg.BinarySHA256, binaryHash))
+}
+
+// archivePath is the path of the executable inside the archive.
+func (c *Component) archivePath() string {
+	if c.cfg.ArchivePath != "" {
+		return c.cfg.ArchivePath
+	}
+	return c.cfg.binary()
+}
+
+// fetchArchiveSum looks up the SHA-256 of the archive in the checksums file.
+func (c *Component) fetchArchiveSum(ctx context.Context, client *download.Client, archive string) (string, error) {
+	var content []byte
+	err := client.Fetch(ctx, c.cached(fmt.Sprintf(c.cfg.ChecksumsURL, c.cfg.Version)), func(body io.Reader) error {
+		var err error
+		content, err = io.ReadAll(body)
+		return err
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to download %s checksums", c.cfg.Name)
+	}
+	return parseChecksums(content, archive)
+}
+
+// cached returns the path of the file of url in the artifact cache if it exists, url otherwise.
+func (c *Component) cached(url string) string {
+	if c.cfg.ArtifactCache == "" {
+		return url
+	}
+	cached := filepath.Join(c.cfg.ArtifactCache, fileName(url))
+	if _, err := os.Stat(cached); err != nil {
+		logrus.Debugf("%s is not in the artifact cache %s: %v", fileName(url), c.cfg.ArtifactCache, err)
+		return url
+	}
+	logrus.Infof("Using %s from the artifact cache instead of %s", cached, url)
+	return cached
+}
+
+// fileName returns the last element of the path of a URL or a local path.
+func fileName(url string) string {
+	if local, ok := download.LocalPath(url); ok {
+		return filepath.Base(local)
+	}
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return path.Base(url)
+	}
+	return path.Base(u.Path)
+}
+
+// copyLegacy copies the binary installed into the working directory before the install root was used to dest if it
+// is the configured version, so that a node that cannot reach the artifact still moves to the install root. The
+// version of the legacy binary was never recorded, so it is identified by the configured checksum, and false is
+// reported if the binary has to be downloaded instead because no checksum is configured or the binary differs.
+func (c *Component) copyLegacy(dest string) (bool, error) {
+	src := c.legacyBinary()
+	if src == "" || c.cfg.BinarySHA256 == "" {
+		return false, nil
+	}
+	sum, err := fileSHA256(src)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to read %s", src)
+	}
+	if !strings.EqualFold(sum, c.cfg.BinarySHA256) {
+		logrus.Infof("%s is not version %s, it has sha256 %s instead of %s.", src, c.cfg.Version, sum, c.cfg.BinarySHA256)
+		return false, nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to open %s", src)
+	}
+	defer func(in *os.File) {
+		_ = in.Close()
+	}(in)
+
+	// the copy is verified again, in case the binary was replaced after it was identified
+	err = download.Stage(dest, func(f *os.File) error {
+		binaryHash := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(f, binaryHash), in); err != nil {
+			return err
+		}
+		return verifySum(src, c.cfg.BinarySHA256, binaryHash)
+	})
+	return err == nil, errors.Wrapf(err, "failed to copy %s to %s", src, dest)
+}
@@ -0,0 +1,52 @@
+package components
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyLegacy(t *testing.T) {
+	const sum = "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+
+	type testCase struct {
+		name          string
+		legacyInstall bool
+		binarySHA256  string
+		copied        bool
+	}
+
+	testCases := []testCase{
+		{name: "matching checksum", legacyInstall: true, binarySHA256: sum, copied: true},
+		{name: "matching checksum in upper case", legacyInstall: true, binarySHA256: "2C26B46B68FFC68FF99B453C1D30413413422D706483BFA0F98A5E886266E7AE", copied: true},
+		{name: "no checksum", legacyInstall: true},
+		{name: "different version", legacyInstall: true, binarySHA256: "0000000000000000000000000000000000000000000000000000000000000000"},
+		{name: "never installed into the working directory", binarySHA256: sum},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			if err := os.WriteFile("csi-proxy.exe", []byte("foo"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			c := &Component{cfg: &Config{Name: "csi-proxy"}}
+			c.cfg.Version = "v1.1.1"
+			c.cfg.BinarySHA256 = tc.binarySHA256
+			c.cfg.LegacyInstall = tc.legacyInstall
+			dest := filepath.Join(t.TempDir(), "csi-proxy.exe")
+
+			copied, err := c.copyLegacy(dest)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if copied != tc.copied {
+				t.Errorf("expected the copy to be %t, got %t", tc.copied, copied)
+			}
+			if _, err := os.Stat(dest); os.IsNotExist(err) == tc.copied {
+				t.Errorf("expected %s to exist to be %t", dest, tc.copied)
+			}
+		})
+	}
+}
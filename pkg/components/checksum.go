@@ -0,0 +1,60 @@
+package components
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+var sha256Hex = regexp.MustCompile(`^[a-fA-F0-9]{64}$`)
+
+// checksumMismatchError is returned when downloaded content does not have the expected SHA-256.
+type checksumMismatchError struct {
+	name     string
+	expected string
+	actual   string
+}
+
+func (e *checksumMismatchError) Error() string {
+	return fmt.Sprintf("checksum mismatch for %s: expected sha256 %s, got %s", e.name, e.expected, e.actual)
+}
+
+// verifySum compares the SHA-256 of content hashed by h with the expected hex digest,
+// an empty expected digest skips the check.
+func verifySum(name, expected string, h hash.Hash) error {
+	if expected == "" {
+		return nil
+	}
+	actual := hex.EncodeToString(h.Sum(nil))
+	if !strings.EqualFold(actual, expected) {
+		logrus.Errorf("Checksum of %s does not match, expected sha256 %s, got %s", name, expected, actual)
+		return &checksumMismatchError{name: name, expected: expected, actual: actual}
+	}
+	logrus.Debugf("Checksum of %s matches sha256 %s", name, actual)
+	return nil
+}
+
+// parseChecksums finds the SHA-256 of file in a checksums file in the format written by sha256sum,
+// i.e. lines of a hex digest followed by the file name, optionally prefixed with '*' for binary mode.
+func parseChecksums(content []byte, file string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || !sha256Hex.MatchString(fields[0]) {
+			continue
+		}
+		if strings.TrimPrefix(fields[1], "*") == file {
+			return fields[0], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("checksums file does not list %s", file)
+}
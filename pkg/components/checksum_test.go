@@ -0,0 +1,40 @@
+package components
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+func TestParseChecksums(t *testing.T) {
+	sum := "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	content := []byte("# CSI Proxy v1.1.1\n" +
+		"0000000000000000000000000000000000000000000000000000000000000000  csi-proxy-v1.1.0.tar.gz\n" +
+		sum + " *csi-proxy-v1.1.1.tar.gz\n")
+
+	found, err := parseChecksums(content, "csi-proxy-v1.1.1.tar.gz")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if found != sum {
+		t.Errorf("expected %s, got %s", sum, found)
+	}
+
+	if _, err := parseChecksums(content, "csi-proxy-v1.2.0.tar.gz"); err == nil {
+		t.Error("expected an error for a file missing from the checksums")
+	}
+}
+
+func TestVerifySum(t *testing.T) {
+	h := sha256.New()
+	h.Write([]byte("foo"))
+
+	if err := verifySum("foo", "2C26B46B68FFC68FF99B453C1D30413413422D706483BFA0F98A5E886266E7AE", h); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := verifySum("foo", "", h); err != nil {
+		t.Errorf("unexpected error without an expected checksum: %v", err)
+	}
+	if err := verifySum("foo", "0000000000000000000000000000000000000000000000000000000000000000", h); err == nil {
+		t.Error("expected a checksum mismatch")
+	}
+}
@@ -0,0 +1,627 @@
+package components
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/wins/pkg/concierge"
+	"github.com/rancher/wins/pkg/defaults"
+	"github.com/rancher/wins/pkg/download"
+	"github.com/rancher/wins/pkg/logs"
+	"github.com/rancher/wins/pkg/scm"
+	winstls "github.com/rancher/wins/pkg/tls"
+	"github.com/sirupsen/logrus"
+)
+
+// serviceStateTimeout is how long a service may take to stop or to reach Running after an upgrade.
+const serviceStateTimeout = time.Minute
+
+// errNotOwned is returned for an existing service of a component that wins did not create, which is left alone.
+var errNotOwned = errors.New("the service was not created by wins")
+
+// manifest records what wins installed for a component, so that it can be removed even after
+// the component was removed from the config.
+type manifest struct {
+	Service  string   `json:"service"`
+	Binary   string   `json:"binary"`
+	LogFiles []string `json:"logFiles,omitempty"`
+}
+
+// Component installs, upgrades and removes a single managed component.
+type Component struct {
+	cfg    *Config
+	tlsCfg *winstls.Config
+	layout layout
+	// binaryPath is the path of the binary the service runs
+	binaryPath string
+	format     download.Format
+	concierge  *concierge.Concierge
+	// capture configures the capture of the output of the service, nil if it is not captured
+	capture *logs.CaptureConfig
+	// expectedSum is the SHA-256 of the installed binary the monitor compares the binary to, empty until
+	// the component is installed or if the binary could not be hashed
+	expectedSum string
+	// installFailures counts the failed attempts of the monitor to install a component without a current
+	// version, the next attempt is not made before nextInstall
+	installFailures int
+	nextInstall     time.Time
+}
+
+// New creates a Component that is installed below the root directory and whose service is managed through
+// manager. Captured output is written to logDir unless the component configures a log file.
+func New(manager scm.Manager, cfg *Config, root, logDir string, tlsCfg *winstls.Config) (*Component, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid component %s", cfg.Name)
+	}
+
+	format, err := download.ParseFormat(cfg.ArchiveFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	l := newLayout(root, cfg)
+	capture := cfg.capture(logDir)
+	service, err := newConcierge(manager, cfg, l, capture)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Component{
+		cfg:        cfg,
+		tlsCfg:     tlsCfg,
+		layout:     l,
+		binaryPath: l.binaryPath(),
+		format:     format,
+		concierge:  service,
+		capture:    capture,
+	}, nil
+}
+
+func newConcierge(manager scm.Manager, cfg *Config, l layout, capture *logs.CaptureConfig) (*concierge.Concierge, error) {
+	path, args := l.binaryPath(), cfg.Service.Args
+	if capture != nil {
+		path, args = l.wrapperPath(), capture.Args(path, args...)
+	}
+	config := concierge.Config{
+		Args:        args,
+		Description: cfg.Service.Description,
+		DisplayName: cfg.displayName(),
+		EnvVars:     cfg.envVars(),
+		Owner:       defaults.WindowsServiceName,
+	}
+	if r := cfg.Service.Recovery; r != nil {
+		// the service manager repeats the last action for further failures
+		restart := scm.RecoveryAction{Type: scm.ServiceRestart, Delay: r.restartDelay}
+		config.RecoveryActions = []scm.RecoveryAction{restart}
+		if r.Restarts > 0 {
+			config.RecoveryActions = slices.Repeat(config.RecoveryActions, r.Restarts)
+			config.RecoveryActions = append(config.RecoveryActions, scm.RecoveryAction{Type: scm.NoAction})
+		}
+		config.RecoveryResetPeriod = uint32(r.resetPeriod / time.Second)
+	}
+	return concierge.NewWithManager(manager, cfg.serviceName(), path, &config)
+}
+
+// Enable installs and starts the component. If it is already installed with a different version
+// than the configured one, the new version is installed next to it and the service is restarted with it.
+// The command line and environment of an installed service are updated to match the config. A service that
+// was not created by wins is not changed, and errNotOwned is returned.
+func (c *Component) Enable(ctx context.Context) error {
+	ok, err := c.concierge.ServiceExists()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		logrus.Infof("%s is being downloaded.", c.cfg.Name)
+		if err := c.install(); err != nil {
+			return err
+		}
+		if err := c.layout.setCurrent(c.cfg.Version); err != nil {
+			return err
+		}
+		logrus.Infof("%s is being started.", c.cfg.Name)
+		if err := c.start(ctx); err != nil {
+			return err
+		}
+		return c.installed()
+	}
+
+	if err := c.adopt(); err != nil {
+		return err
+	}
+
+	current, err := c.layout.currentVersion()
+	if err != nil {
+		return err
+	}
+	if current == c.cfg.Version {
+		if err := c.reconcile(ctx); err != nil {
+			return err
+		}
+		return c.installed()
+	}
+	if current == "" {
+		if legacy := c.legacyBinary(); legacy != "" {
+			logrus.Infof("%s is installed as %s, installing version %s into %s and removing the legacy binary.", c.cfg.Name, legacy, c.cfg.Version, c.layout.dir)
+		} else {
+			logrus.Infof("The installed %s version is unknown, replacing it with version %s.", c.cfg.Name, c.cfg.Version)
+		}
+	} else {
+		logrus.Infof("%s version %s is installed, replacing it with version %s.", c.cfg.Name, current, c.cfg.Version)
+	}
+	return c.upgrade(ctx, current)
+}
+
+// install downloads the configured version into its version directory, together with the svc-wrap copy of
+// wins if the output of the service is captured. The binary installed before the install root was used is
+// copied instead if it matches the configured checksum.
+func (c *Component) install() error {
+	if err := os.MkdirAll(c.layout.versionDir(c.cfg.Version), os.ModePerm); err != nil {
+		return err
+	}
+	copied, err := c.copyLegacy(c.layout.versionBinary(c.cfg.Version))
+	if err != nil {
+		return err
+	}
+	if copied {
+		logrus.Infof("%s version %s was copied from %s.", c.cfg.Name, c.cfg.Version, legacyDir())
+	} else if err := c.download(c.layout.versionBinary(c.cfg.Version)); err != nil {
+		return err
+	}
+	if c.capture == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(c.capture.File), os.ModePerm); err != nil {
+		return errors.Wrapf(err, "failed to create the log directory of %s", c.cfg.Name)
+	}
+	return installWrapper(c.layout.versionWrapper(c.cfg.Version))
+}
+
+// wrapperOutdated reports whether the output of the service is captured and the svc-wrap copy of the current
+// version is missing or differs from the running wins binary, e.g. after wins was upgraded.
+func (c *Component) wrapperOutdated() (bool, error) {
+	if c.capture == nil {
+		return false, nil
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return false, err
+	}
+	expected, err := fileSHA256(self)
+	if err != nil {
+		return false, errors.Wrapf(err, "could not hash %s", self)
+	}
+	sum, err := fileSHA256(c.layout.wrapperPath())
+	if err != nil && !os.IsNotExist(err) {
+		return false, errors.Wrapf(err, "could not hash %s", c.layout.wrapperPath())
+	}
+	return sum != expected, nil
+}
+
+// installWrapper copies the running wins binary to dest.
+func installWrapper(dest string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(self)
+	if err != nil {
+		return err
+	}
+	tmp := dest + ".tmp"
+	if err := os.WriteFile(tmp, content, os.ModePerm); err != nil {
+		return errors.Wrapf(err, "failed to write %s", tmp)
+	}
+	return errors.Wrapf(os.Rename(tmp, dest), "failed to install %s", dest)
+}
+
+// upgrade installs the configured version next to the previous one and points the service to it. The
+// current pointer is flipped back to the previous version if the new version does not reach Running.
+func (c *Component) upgrade(ctx context.Context, previous string) error {
+	logrus.Infof("%s version %s is being installed.", c.cfg.Name, c.cfg.Version)
+	if err := c.install(); err != nil {
+		return err
+	}
+
+	if err := c.stop(ctx); err != nil {
+		return err
+	}
+
+	if err := c.layout.setCurrent(c.cfg.Version); err != nil {
+		return c.rollback(ctx, previous, err)
+	}
+	if _, err := c.concierge.Reconcile(); err != nil {
+		return c.rollback(ctx, previous, errors.Wrapf(err, "failed to update the %s service", c.cfg.Name))
+	}
+
+	logrus.Infof("%s version %s is being started.", c.cfg.Name, c.cfg.Version)
+	if err := c.start(ctx); err != nil {
+		return c.rollback(ctx, previous, errors.Wrapf(err, "%s version %s did not start", c.cfg.Name, c.cfg.Version))
+	}
+
+	if previous != "" {
+		logrus.Infof("%s was upgraded to version %s, version %s is kept for rollbacks.", c.cfg.Name, c.cfg.Version, previous)
+	} else {
+		logrus.Infof("%s version %s was installed into %s.", c.cfg.Name, c.cfg.Version, c.layout.dir)
+	}
+	return c.installed()
+}
+
+// installed records the manifest and the checksum of the binary after a successful installation, and removes
+// the versions that are not retained as well as the files installed before the install root was used.
+func (c *Component) installed() error {
+	c.expectedSum = c.cfg.BinarySHA256
+	if c.expectedSum == "" {
+		sum, err := fileSHA256(c.binaryPath)
+		if err != nil {
+			logrus.Warnf("Could not hash %s, changes to the %s binary are not detected: %v", c.binaryPath, c.cfg.Name, err)
+		}
+		c.expectedSum = sum
+	}
+
+	m := manifest{
+		Service:  c.cfg.serviceName(),
+		Binary:   c.cfg.binary(),
+		LogFiles: c.cfg.LogFiles,
+	}
+	if c.capture != nil {
+		m.LogFiles = append(slices.Clone(m.LogFiles), c.capture.File)
+	}
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(c.layout.manifestPath(), content, os.ModePerm); err != nil {
+		return errors.Wrapf(err, "failed to record the manifest of %s", c.cfg.Name)
+	}
+
+	if err := c.layout.prune(c.cfg.retainedVersions()); err != nil {
+		logrus.Warnf("could not prune the versions of %s: %v", c.cfg.Name, err)
+	}
+	if c.cfg.LegacyInstall {
+		removeLegacy(c.cfg.Name, c.cfg.binary())
+	}
+	return nil
+}
+
+// adopt marks a service that runs the binary installed by wins, but was created before services
+// were marked with their owner, as owned by wins. It returns errNotOwned for any other service.
+func (c *Component) adopt() error {
+	owned, err := c.concierge.Owned()
+	if err != nil || owned {
+		return err
+	}
+
+	cmd, err := c.concierge.CommandLine()
+	if err != nil {
+		return err
+	}
+	cmd = logs.UnwrapCommandLine(cmd)
+	if len(cmd) == 0 || !c.installedBinary(cmd[0]) {
+		return errors.Wrapf(errNotOwned, "the %s service is left alone and %s is not installed", c.cfg.serviceName(), c.cfg.Name)
+	}
+	logrus.Infof("Marking the existing %s service as managed by wins.", c.cfg.serviceName())
+	return c.concierge.MarkOwned()
+}
+
+// installedBinary reports whether path is where wins installs or installed the binary of the component.
+func (c *Component) installedBinary(path string) bool {
+	return installedBinary(path, c.binaryPath, c.cfg.binary(), c.cfg.LegacyInstall)
+}
+
+// installedBinary reports whether path is binaryPath, or the binary in the working directory of wins if the
+// component was installed there before the install root was used.
+func installedBinary(path, binaryPath, binary string, legacy bool) bool {
+	paths := []string{binaryPath}
+	if legacy {
+		paths = append(paths, filepath.Join(legacyDir(), binary))
+	}
+	for _, p := range paths {
+		if strings.EqualFold(filepath.Clean(path), filepath.Clean(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// reconcile applies the config to the installed service and replaces an outdated svc-wrap copy of wins,
+// restarting the service if a change requires it.
+func (c *Component) reconcile(ctx context.Context) error {
+	outdated, err := c.wrapperOutdated()
+	if err != nil {
+		return err
+	}
+	if outdated {
+		logrus.Infof("The svc-wrap binary of %s is outdated, replacing it.", c.cfg.Name)
+		if err := c.stop(ctx); err != nil {
+			return err
+		}
+		if err := installWrapper(c.layout.wrapperPath()); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(c.capture.File), os.ModePerm); err != nil {
+			return errors.Wrapf(err, "failed to create the log directory of %s", c.cfg.Name)
+		}
+	}
+
+	changes, err := c.concierge.Reconcile()
+	if err != nil {
+		return errors.Wrapf(err, "failed to update the %s service", c.cfg.Name)
+	}
+	if !changes.RestartRequired && !outdated {
+		return nil
+	}
+
+	if changes.RestartRequired {
+		logrus.Infof("The %s of the %s service changed, restarting it.", strings.Join(changes.Fields, ", "), c.cfg.Name)
+	}
+	return c.restartService(ctx)
+}
+
+// rollback points the service back to the previous version after a failed upgrade and starts it again,
+// returning the upgrade error. Without a previous version, the failed version is stopped and not treated
+// as installed, so that it is not restarted, and does not replace a binary installed before the install root
+// was used, until the monitor installs it again.
+func (c *Component) rollback(ctx context.Context, previous string, upgradeErr error) error {
+	if previous == "" {
+		healthy.WithLabelValues(c.cfg.Name).Set(0)
+		logrus.Errorf("The %s upgrade failed and cannot be rolled back, there is no previous version. The %s service is left stopped: %v", c.cfg.Name, c.cfg.serviceName(), upgradeErr)
+		if err := c.stop(ctx); err != nil {
+			logrus.Warnf("could not stop %s after the failed upgrade: %v", c.cfg.Name, err)
+		}
+		if err := c.layout.clearCurrent(); err != nil {
+			logrus.Warnf("could not remove the current version of %s: %v", c.cfg.Name, err)
+		}
+		return errors.Wrapf(upgradeErr, "%s was not rolled back, there is no previous version", c.cfg.Name)
+	}
+
+	logrus.Errorf("Rolling back the %s upgrade: %v", c.cfg.Name, upgradeErr)
+	if err := c.stop(ctx); err != nil {
+		return errors.Wrapf(upgradeErr, "failed to stop %s for the rollback: %v", c.cfg.Name, err)
+	}
+	if err := c.layout.setCurrent(previous); err != nil {
+		return errors.Wrapf(upgradeErr, "failed to restore %s version %s: %v", c.cfg.Name, previous, err)
+	}
+	if err := c.start(ctx); err != nil {
+		return errors.Wrapf(upgradeErr, "failed to start %s version %s: %v", c.cfg.Name, previous, err)
+	}
+	// the configured checksum is the one of the failed version
+	sum, err := fileSHA256(c.binaryPath)
+	if err != nil {
+		logrus.Warnf("Could not hash %s, changes to the %s binary are not detected: %v", c.binaryPath, c.cfg.Name, err)
+	}
+	c.expectedSum = sum
+	return upgradeErr
+}
+
+// start starts the service and waits until it is running.
+func (c *Component) start(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, serviceStateTimeout)
+	defer cancel()
+	return c.concierge.Enable(ctx)
+}
+
+// stop stops the service and waits until it has stopped, so that its binary can be replaced.
+func (c *Component) stop(ctx context.Context) error {
+	return stopService(ctx, c.concierge)
+}
+
+func stopService(ctx context.Context, service *concierge.Concierge) error {
+	ctx, cancel := context.WithTimeout(ctx, serviceStateTimeout)
+	defer cancel()
+	return service.Disable(ctx)
+}
+
+// readManifest returns the manifest of the component installed in dir, an empty manifest if there is none.
+func readManifest(l layout) (*manifest, error) {
+	m := &manifest{}
+	content, err := os.ReadFile(l.manifestPath())
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, m); err != nil {
+		return nil, errors.Wrapf(err, "invalid manifest %s", l.manifestPath())
+	}
+	return m, nil
+}
+
+// legacyDir is the working directory of wins, where binaries were installed before the install root was configurable.
+func legacyDir() string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		logrus.Warnf("could not determine the working directory: %v", err)
+		return ""
+	}
+	return cwd
+}
+
+// legacyBinary returns the path of the binary installed into the working directory before the install root was
+// used, or an empty string if the component was never installed there or the binary does not exist anymore.
+func (c *Component) legacyBinary() string {
+	dir := legacyDir()
+	if !c.cfg.LegacyInstall || dir == "" {
+		return ""
+	}
+	path := filepath.Join(dir, c.cfg.binary())
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// removeLegacy removes the binary installed into the working directory before the install root was used.
+func removeLegacy(name, binary string) {
+	dir := legacyDir()
+	if dir == "" {
+		return
+	}
+	path := filepath.Join(dir, binary)
+	if err := os.Remove(path); err != nil {
+		if !os.IsNotExist(err) {
+			logrus.Warnf("could not remove %s: %v", path, err)
+		}
+		return
+	}
+	logrus.Infof("removed %s, which was installed before %s was moved to the install root", path, name)
+}
+
+// Remove stops and deletes the service of the component, and removes the files wins installed for it.
+// Services that were not created by wins are left alone.
+func Remove(ctx context.Context, manager scm.Manager, cfg *Config, root string) error {
+	l := newLayout(root, cfg)
+	m, err := readManifest(l)
+	if err != nil {
+		return err
+	}
+	if m.Service == "" {
+		m.Service = cfg.serviceName()
+	}
+	if m.Binary == "" {
+		m.Binary = cfg.binary()
+	}
+	if len(m.LogFiles) == 0 {
+		m.LogFiles = cfg.LogFiles
+	}
+	return remove(ctx, manager, cfg.Name, l, m, cfg.LegacyInstall)
+}
+
+// remove deletes the service and the files of a component, and its legacy binary if it was installed
+// into the working directory before the install root was used.
+func remove(ctx context.Context, manager scm.Manager, name string, l layout, m *manifest, legacy bool) error {
+	l.binary = m.Binary
+	service, err := concierge.NewWithManager(manager, m.Service, l.binaryPath(), &concierge.Config{Owner: defaults.WindowsServiceName})
+	if err != nil {
+		return err
+	}
+
+	ok, err := service.ServiceExists()
+	if err != nil {
+		return err
+	}
+	if ok {
+		owned, err := service.Owned()
+		if err != nil {
+			return err
+		}
+		// services created before services were marked with their owner run the binary wins installed
+		if !owned && legacy {
+			cmd, err := service.CommandLine()
+			if err != nil {
+				return err
+			}
+			cmd = logs.UnwrapCommandLine(cmd)
+			owned = len(cmd) > 0 && installedBinary(cmd[0], l.binaryPath(), m.Binary, legacy)
+		}
+		if !owned {
+			logrus.Infof("%s is not configured, but the %s service was not created by wins and is left in place.", name, m.Service)
+			return nil
+		}
+
+		logrus.Infof("%s is not configured, removing the %s service.", name, m.Service)
+	}
+
+	var paths []string
+	for _, f := range m.LogFiles {
+		rotated, err := logs.RotatedFiles(f)
+		if err != nil {
+			logrus.Warnf("could not find the rotated files of %s: %v", f, err)
+		}
+		paths = append(paths, rotated...)
+		paths = append(paths, f)
+	}
+	paths = append(paths, l.dir)
+
+	ctx, cancel := context.WithTimeout(ctx, serviceStateTimeout)
+	defer cancel()
+	report := service.Delete(ctx, paths...)
+	logrus.Info(report)
+	if err := report.Err(); err != nil {
+		return errors.Wrapf(err, "failed to remove %s", name)
+	}
+	if legacy {
+		removeLegacy(name, m.Binary)
+	}
+	return nil
+}
+
+// Prune removes the components installed below root that are not in cfgs anymore.
+func Prune(ctx context.Context, manager scm.Manager, cfgs []Config, root string) error {
+	manifests, err := filepath.Glob(filepath.Join(root, "*", manifestName))
+	if err != nil {
+		return err
+	}
+
+	configured := map[string]bool{}
+	for _, cfg := range cfgs {
+		configured[cfg.Name] = true
+	}
+
+	for _, path := range manifests {
+		l := layout{dir: filepath.Dir(path)}
+		name := filepath.Base(l.dir)
+		if configured[name] {
+			continue
+		}
+		m, err := readManifest(l)
+		if err != nil {
+			return err
+		}
+		if m.Service == "" || m.Binary == "" {
+			logrus.Warnf("The manifest %s is incomplete, %s is not removed", path, name)
+			continue
+		}
+		// only components that are always configured were installed into the working directory
+		if err := remove(ctx, manager, name, l, m, false); err != nil {
+			return errors.Wrapf(err, "failed to remove %s", name)
+		}
+	}
+	return nil
+}
+
+// Reconcile installs and starts monitoring the enabled components below root, and removes the disabled ones and
+// the ones that are not configured anymore. Failing components are logged and do not prevent the others, or wins,
+// from starting, the monitor of an enabled component retries it. Only a failure to create root is returned.
+func Reconcile(ctx context.Context, manager scm.Manager, cfgs []Config, root, logDir string, tlsCfg *winstls.Config) error {
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		return errors.Wrapf(err, "failed to create the install root %s", root)
+	}
+
+	for i := range cfgs {
+		cfg := &cfgs[i]
+		if err := reconcileComponent(ctx, manager, cfg, root, logDir, tlsCfg); err != nil {
+			logrus.Errorf("Failed to reconcile %s: %v", cfg.Name, err)
+		}
+	}
+
+	if err := Prune(ctx, manager, cfgs, root); err != nil {
+		logrus.Errorf("Failed to remove the components that are not configured anymore: %v", err)
+	}
+	return nil
+}
+
+func reconcileComponent(ctx context.Context, manager scm.Manager, cfg *Config, root, logDir string, tlsCfg *winstls.Config) error {
+	if !cfg.IsEnabled() {
+		return Remove(ctx, manager, cfg, root)
+	}
+
+	logrus.Infof("%s will be enabled as a Windows service.", cfg.Name)
+	c, err := New(manager, cfg, root, logDir, tlsCfg)
+	if err != nil {
+		return err
+	}
+	// the monitor installs a component that could not be enabled, e.g. because its download failed, once it can
+	if err := c.Enable(ctx); err != nil {
+		logrus.Errorf("Failed to enable %s, it is retried by its monitor: %v", cfg.Name, err)
+	}
+	go c.Monitor(ctx)
+	return nil
+}
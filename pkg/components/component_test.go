@@ -0,0 +1,385 @@
+package components
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/wins/pkg/scm"
+)
+
+const testService = "exporter"
+
+// failingManager is a Fake whose services fail to start while the current version of the component is failing.
+type failingManager struct {
+	*scm.Fake
+	layout  layout
+	failing string
+}
+
+func (m *failingManager) OpenService(name string) (scm.Service, error) {
+	s, err := m.Fake.OpenService(name)
+	if err != nil {
+		return nil, err
+	}
+	return &failingService{Service: s, manager: m}, nil
+}
+
+type failingService struct {
+	scm.Service
+	manager *failingManager
+}
+
+func (s *failingService) Start(args ...string) error {
+	current, _ := s.manager.layout.currentVersion()
+	s.manager.SetFailToStart(s.Name(), s.manager.failing != "" && current == s.manager.failing)
+	return s.Service.Start(args...)
+}
+
+// testEnv installs the exporter component from local artifacts of the versions v1 and v2.
+type testEnv struct {
+	t         *testing.T
+	root      string
+	artifacts string
+	manager   *failingManager
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	env := &testEnv{t: t, root: t.TempDir(), artifacts: t.TempDir()}
+	for _, version := range []string{"v1", "v2"} {
+		if err := os.WriteFile(filepath.Join(env.artifacts, "exporter-"+version+".exe"), []byte("MZ"+version), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	env.manager = &failingManager{Fake: scm.NewFake(), layout: layout{dir: filepath.Join(env.root, testService), binary: "exporter.exe"}}
+	return env
+}
+
+func (env *testEnv) config(version string) *Config {
+	return &Config{
+		Name:     testService,
+		Artifact: Artifact{URL: filepath.Join(env.artifacts, "exporter-%s.exe"), Version: version},
+		Service:  Service{Args: []string{"--port", "9182"}},
+	}
+}
+
+func (env *testEnv) component(cfg *Config) *Component {
+	c, err := New(env.manager, cfg, env.root, "", nil)
+	if err != nil {
+		env.t.Fatal(err)
+	}
+	return c
+}
+
+// install enables version as a precondition of a test.
+func (env *testEnv) install(version string) *Component {
+	c := env.component(env.config(version))
+	if err := c.Enable(context.Background()); err != nil {
+		env.t.Fatalf("failed to install %s: %v", version, err)
+	}
+	return c
+}
+
+// addService creates the service outside of wins, running exePath.
+func (env *testEnv) addService(exePath string, state scm.State) {
+	env.manager.Add(testService, scm.Config{BinaryPathName: scm.JoinCommandLine(exePath)}, state)
+}
+
+func (env *testEnv) service() scm.FakeService {
+	s, ok := env.manager.Service(testService)
+	if !ok {
+		env.t.Fatalf("expected the %s service to exist", testService)
+	}
+	return s
+}
+
+func (env *testEnv) currentVersion() string {
+	current, err := env.manager.layout.currentVersion()
+	if err != nil {
+		env.t.Fatal(err)
+	}
+	return current
+}
+
+func TestEnable(t *testing.T) {
+	type testCase struct {
+		name    string
+		version string
+		// setup prepares the node, the version of the component that is enabled fails to start if failing is set
+		setup     func(t *testing.T, env *testEnv)
+		failing   bool
+		legacy    bool
+		configure func(cfg *Config)
+		check     func(t *testing.T, env *testEnv, err error)
+	}
+
+	testCases := []testCase{
+		{
+			name:    "fresh install",
+			version: "v1",
+			check: func(t *testing.T, env *testEnv, err error) {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				s := env.service()
+				if s.Status.State != scm.Running {
+					t.Errorf("expected the service to be running, got %s", s.Status.State)
+				}
+				expected := scm.JoinCommandLine(env.manager.layout.binaryPath(), "--port", "9182")
+				if s.Config.BinaryPathName != expected {
+					t.Errorf("expected the service to run %s, got %s", expected, s.Config.BinaryPathName)
+				}
+				if env.currentVersion() != "v1" {
+					t.Errorf("expected current version v1, got %s", env.currentVersion())
+				}
+				if _, err := os.Stat(env.manager.layout.manifestPath()); err != nil {
+					t.Errorf("expected the manifest to be recorded: %v", err)
+				}
+			},
+		},
+		{
+			name:    "same version reconcile",
+			version: "v1",
+			setup: func(t *testing.T, env *testEnv) {
+				env.install("v1")
+			},
+			configure: func(cfg *Config) {
+				cfg.Service.Env = map[string]string{"LOG_LEVEL": "debug"}
+			},
+			check: func(t *testing.T, env *testEnv, err error) {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				s := env.service()
+				if len(s.Environment) != 1 || s.Environment[0] != "LOG_LEVEL=debug" {
+					t.Errorf("expected the environment to be updated, got %v", s.Environment)
+				}
+				if s.Starts != 2 || s.Status.State != scm.Running {
+					t.Errorf("expected the service to be restarted for the new environment, got %d starts in state %s", s.Starts, s.Status.State)
+				}
+				if env.currentVersion() != "v1" {
+					t.Errorf("expected current version v1, got %s", env.currentVersion())
+				}
+			},
+		},
+		{
+			name:    "upgrade",
+			version: "v2",
+			setup: func(t *testing.T, env *testEnv) {
+				env.install("v1")
+			},
+			check: func(t *testing.T, env *testEnv, err error) {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if s := env.service(); s.Starts != 2 || s.Status.State != scm.Running {
+					t.Errorf("expected the service to be restarted, got %d starts in state %s", s.Starts, s.Status.State)
+				}
+				if env.currentVersion() != "v2" {
+					t.Errorf("expected current version v2, got %s", env.currentVersion())
+				}
+				if _, err := os.Stat(env.manager.layout.versionBinary("v1")); err != nil {
+					t.Errorf("expected v1 to be retained for rollbacks: %v", err)
+				}
+			},
+		},
+		{
+			name:    "upgrade with rollback",
+			version: "v2",
+			failing: true,
+			setup: func(t *testing.T, env *testEnv) {
+				env.install("v1")
+			},
+			check: func(t *testing.T, env *testEnv, err error) {
+				if err == nil {
+					t.Fatal("expected the upgrade to fail")
+				}
+				if s := env.service(); s.Status.State != scm.Running {
+					t.Errorf("expected the previous version to run, got state %s", s.Status.State)
+				}
+				if env.currentVersion() != "v1" {
+					t.Errorf("expected current version v1 after the rollback, got %s", env.currentVersion())
+				}
+			},
+		},
+		{
+			name:    "failed upgrade without previous version",
+			version: "v1",
+			failing: true,
+			setup: func(t *testing.T, env *testEnv) {
+				env.addService(env.manager.layout.binaryPath(), scm.Stopped)
+			},
+			check: func(t *testing.T, env *testEnv, err error) {
+				if err == nil {
+					t.Fatal("expected the upgrade to fail")
+				}
+				if s := env.service(); s.Status.State != scm.Stopped {
+					t.Errorf("expected the service to be left stopped, got state %s", s.Status.State)
+				}
+				if env.currentVersion() != "" {
+					t.Errorf("expected the failed version not to be current, got %s", env.currentVersion())
+				}
+			},
+		},
+		{
+			name:    "foreign service refusal",
+			version: "v1",
+			setup: func(t *testing.T, env *testEnv) {
+				env.addService(`c:\program files\exporter\exporter.exe`, scm.Running)
+			},
+			check: func(t *testing.T, env *testEnv, err error) {
+				if !errors.Is(err, errNotOwned) {
+					t.Fatalf("expected errNotOwned, got %v", err)
+				}
+				s := env.service()
+				if s.Config.BinaryPathName != scm.JoinCommandLine(`c:\program files\exporter\exporter.exe`) || s.Starts != 0 {
+					t.Errorf("expected the foreign service to be left alone, got %s with %d starts", s.Config.BinaryPathName, s.Starts)
+				}
+				if _, err := os.Stat(env.manager.layout.dir); !os.IsNotExist(err) {
+					t.Errorf("expected nothing to be installed, got %v", err)
+				}
+			},
+		},
+		{
+			name:    "legacy service adoption",
+			version: "v1",
+			legacy:  true,
+			setup: func(t *testing.T, env *testEnv) {
+				t.Chdir(t.TempDir())
+				cwd, err := os.Getwd()
+				if err != nil {
+					t.Fatal(err)
+				}
+				env.addService(filepath.Join(cwd, "exporter.exe"), scm.Running)
+			},
+			check: func(t *testing.T, env *testEnv, err error) {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if s := env.service(); s.Values["ManagedBy"] == "" || s.Status.State != scm.Running {
+					t.Errorf("expected the legacy service to be adopted and running, got %+v", s)
+				}
+				if env.currentVersion() != "v1" {
+					t.Errorf("expected current version v1, got %s", env.currentVersion())
+				}
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			if tc.setup != nil {
+				tc.setup(t, env)
+			}
+			if tc.failing {
+				env.manager.failing = tc.version
+			}
+			cfg := env.config(tc.version)
+			cfg.LegacyInstall = tc.legacy
+			if tc.configure != nil {
+				tc.configure(cfg)
+			}
+			tc.check(t, env, env.component(cfg).Enable(context.Background()))
+		})
+	}
+}
+
+func TestRemove(t *testing.T) {
+	type testCase struct {
+		name    string
+		legacy  bool
+		setup   func(t *testing.T, env *testEnv)
+		removed bool
+	}
+
+	testCases := []testCase{
+		{
+			name: "owned service",
+			setup: func(t *testing.T, env *testEnv) {
+				env.install("v1")
+			},
+			removed: true,
+		},
+		{
+			name: "unowned service",
+			setup: func(t *testing.T, env *testEnv) {
+				env.addService(`c:\program files\exporter\exporter.exe`, scm.Running)
+			},
+		},
+		{
+			name:   "unmarked legacy service",
+			legacy: true,
+			setup: func(t *testing.T, env *testEnv) {
+				t.Chdir(t.TempDir())
+				if err := os.WriteFile("exporter.exe", []byte("MZ"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				cwd, err := os.Getwd()
+				if err != nil {
+					t.Fatal(err)
+				}
+				env.addService(filepath.Join(cwd, "exporter.exe"), scm.Running)
+			},
+			removed: true,
+		},
+		{
+			name: "unmarked service of a component that was never installed into the working directory",
+			setup: func(t *testing.T, env *testEnv) {
+				t.Chdir(t.TempDir())
+				cwd, err := os.Getwd()
+				if err != nil {
+					t.Fatal(err)
+				}
+				env.addService(filepath.Join(cwd, "exporter.exe"), scm.Running)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			tc.setup(t, env)
+			cfg := env.config("v1")
+			cfg.LegacyInstall = tc.legacy
+
+			if err := Remove(context.Background(), env.manager, cfg, env.root); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, exists := env.manager.Service(testService); exists == tc.removed {
+				t.Errorf("expected the service to be removed: %t, but it exists: %t", tc.removed, exists)
+			}
+			if _, err := os.Stat(env.manager.layout.dir); tc.removed && !os.IsNotExist(err) {
+				t.Errorf("expected the install directory to be removed, got %v", err)
+			}
+			if _, err := os.Stat("exporter.exe"); tc.legacy && !os.IsNotExist(err) {
+				t.Errorf("expected the legacy binary to be removed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestPrune(t *testing.T) {
+	env := newTestEnv(t)
+	env.install("v1")
+
+	// configured components are kept
+	if err := Prune(context.Background(), env.manager, []Config{*env.config("v1")}, env.root); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, exists := env.manager.Service(testService); !exists {
+		t.Fatal("expected the configured component to be kept")
+	}
+
+	if err := Prune(context.Background(), env.manager, nil, env.root); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, exists := env.manager.Service(testService); exists {
+		t.Error("expected the service of the component that is not configured anymore to be removed")
+	}
+	if _, err := os.Stat(env.manager.layout.dir); !os.IsNotExist(err) {
+		t.Errorf("expected the install directory to be removed, got %v", err)
+	}
+}
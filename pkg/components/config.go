@@ -0,0 +1,270 @@
+package components
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/wins/pkg/download"
+	"github.com/rancher/wins/pkg/logs"
+)
+
+// defaultRetainedVersions keeps the previous version next to the current one, so that it can be rolled back to.
+const defaultRetainedVersions = 2
+
+var componentName = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// Artifact describes where the executable of a component is downloaded from and how it is verified.
+type Artifact struct {
+	// URL is formatted for Go sprintf with the version. Besides http and https URLs, it can be a file:// URL
+	// or a local path to install from an archive that is already on the node.
+	URL     string `yaml:"url" json:"url"`
+	Version string `yaml:"version" json:"version"`
+	// ArchiveSHA256 is the expected SHA-256 of the downloaded archive.
+	ArchiveSHA256 string `yaml:"archiveSHA256" json:"archiveSHA256,omitempty"`
+	// BinarySHA256 is the expected SHA-256 of the executable extracted from the archive.
+	BinarySHA256 string `yaml:"binarySHA256" json:"binarySHA256,omitempty"`
+	// ChecksumsURL is a checksums file in sha256sum format that lists the archive. Like URL, it is
+	// expected to be formatted for Go sprintf with the version.
+	ChecksumsURL string `yaml:"checksumsURL" json:"checksumsURL,omitempty"`
+	// ArchiveFormat is one of tar.gz, zip or exe, it is detected from the content and the file name if empty.
+	ArchiveFormat string `yaml:"archiveFormat" json:"archiveFormat,omitempty"`
+	// ArchivePath is the path of the executable inside the archive. Without a directory, the archive
+	// must contain exactly one file with that name. Defaults to the file name of the binary.
+	ArchivePath string `yaml:"archivePath" json:"archivePath,omitempty"`
+	// Signature requires the artifact to have a detached signature by a trusted key.
+	Signature *download.SignatureConfig `yaml:"signature" json:"signature,omitempty"`
+	// ArtifactCache is a directory that is checked for the archive and the checksums file, by the file
+	// names of their URLs, before downloading them.
+	ArtifactCache string `yaml:"artifactCache" json:"artifactCache,omitempty"`
+}
+
+func (a *Artifact) validate() error {
+	if strings.TrimSpace(a.URL) == "" {
+		return errors.New("url cannot be empty")
+	}
+
+	if strings.TrimSpace(a.Version) == "" {
+		return errors.New("version cannot be empty")
+	}
+
+	// every version is installed into a directory named after it
+	if strings.ContainsAny(a.Version, `/\:`) || a.Version == "." || a.Version == ".." || a.Version == currentName {
+		return fmt.Errorf("version %s cannot be used as a directory name", a.Version)
+	}
+
+	for name, sum := range map[string]string{"archiveSHA256": a.ArchiveSHA256, "binarySHA256": a.BinarySHA256} {
+		if sum != "" && !sha256Hex.MatchString(sum) {
+			return fmt.Errorf("%s must be a hex encoded SHA-256", name)
+		}
+	}
+
+	if a.ArchiveSHA256 != "" && a.ChecksumsURL != "" {
+		return errors.New("archiveSHA256 and checksumsURL cannot both be set")
+	}
+
+	if _, err := download.ParseFormat(a.ArchiveFormat); err != nil {
+		return errors.Wrap(err, "invalid archiveFormat")
+	}
+
+	if a.Signature != nil {
+		if err := a.Signature.Validate(); err != nil {
+			return errors.Wrap(err, "invalid signature")
+		}
+	}
+	return nil
+}
+
+// Config is a component that wins installs from an artifact and keeps running as a Windows service.
+type Config struct {
+	Name string `yaml:"name" json:"name"`
+	// Enabled defaults to true. If it is false, the component is removed from the node like when it is not configured.
+	Enabled  *bool `yaml:"enabled" json:"enabled,omitempty"`
+	Artifact `yaml:",inline"`
+	// Binary is the file name the executable is installed as, defaults to <name>.exe.
+	Binary  string  `yaml:"binary" json:"binary,omitempty"`
+	Service Service `yaml:"service" json:"service"`
+	// HealthCheck configures the monitoring of the component after it was installed.
+	HealthCheck *HealthCheck `yaml:"healthCheck" json:"healthCheck,omitempty"`
+	// LogFiles written by the component are removed together with it.
+	LogFiles []string `yaml:"logFiles" json:"logFiles,omitempty"`
+	// RetainedVersions is the number of installed versions that are kept, including the current one, defaults to 2.
+	RetainedVersions int `yaml:"retainedVersions" json:"retainedVersions,omitempty"`
+	// LegacyInstall is set for components that wins installed into its working directory before the install root
+	// was used, so that their binary and service are adopted and the legacy binary is removed. Only CSI Proxy was.
+	LegacyInstall bool `yaml:"-" json:"-"`
+}
+
+// Service is the Windows service that runs the component.
+type Service struct {
+	// Name defaults to the name of the component.
+	Name        string            `yaml:"name" json:"name,omitempty"`
+	DisplayName string            `yaml:"displayName" json:"displayName,omitempty"`
+	Description string            `yaml:"description" json:"description,omitempty"`
+	Args        []string          `yaml:"args" json:"args,omitempty"`
+	Env         map[string]string `yaml:"env" json:"env,omitempty"`
+	Recovery    *Recovery         `yaml:"recovery" json:"recovery,omitempty"`
+	// CaptureOutput runs the component through the svc-wrap command of wins, which writes its stdout and
+	// stderr into a rotated log file.
+	CaptureOutput *logs.CaptureConfig `yaml:"captureOutput" json:"captureOutput,omitempty"`
+}
+
+// Recovery configures how the service manager restarts the service when it fails.
+type Recovery struct {
+	// Restarts is the number of consecutive failures the service is restarted after, it is always restarted if unset.
+	Restarts int `yaml:"restarts" json:"restarts,omitempty"`
+	// RestartDelay is how long to wait before a restart, defaults to 10s.
+	RestartDelay string `yaml:"restartDelay" json:"restartDelay,omitempty"`
+	// ResetPeriod is how long the service has to run without failures for the failure count to be reset.
+	ResetPeriod string `yaml:"resetPeriod" json:"resetPeriod,omitempty"`
+
+	restartDelay time.Duration
+	resetPeriod  time.Duration
+}
+
+func (r *Recovery) validate() error {
+	if r.Restarts < 0 {
+		return errors.New("restarts cannot be negative")
+	}
+
+	r.restartDelay = 10 * time.Second
+	if r.RestartDelay != "" {
+		d, err := time.ParseDuration(r.RestartDelay)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid restartDelay %s", r.RestartDelay)
+		}
+		r.restartDelay = d
+	}
+	if r.ResetPeriod != "" {
+		d, err := time.ParseDuration(r.ResetPeriod)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid resetPeriod %s", r.ResetPeriod)
+		}
+		r.resetPeriod = d
+	}
+	return nil
+}
+
+// IsEnabled reports whether the component should be installed.
+func (c *Config) IsEnabled() bool {
+	return c.Enabled == nil || *c.Enabled
+}
+
+// Validate ensures that the configuration of the component is correct. Only the identity of
+// disabled components is validated, as they are only removed.
+func (c *Config) Validate() error {
+	if !componentName.MatchString(c.Name) {
+		return fmt.Errorf("component name %q must consist of lower case alphanumeric characters or '-'", c.Name)
+	}
+	if strings.ContainsAny(c.Binary, `/\`) {
+		return errors.New("binary must be a file name")
+	}
+	if !c.IsEnabled() {
+		return nil
+	}
+
+	if c.RetainedVersions < 0 {
+		return errors.New("retainedVersions cannot be negative")
+	}
+
+	if err := c.Artifact.validate(); err != nil {
+		return err
+	}
+
+	for name := range c.Service.Env {
+		if name == "" || strings.ContainsAny(name, "=\x00") {
+			return fmt.Errorf("invalid environment variable name %q", name)
+		}
+	}
+	if c.Service.Recovery != nil {
+		if err := c.Service.Recovery.validate(); err != nil {
+			return errors.Wrap(err, "invalid recovery")
+		}
+	}
+	if c.Service.CaptureOutput != nil {
+		if err := c.Service.CaptureOutput.Validate(); err != nil {
+			return errors.Wrap(err, "invalid captureOutput")
+		}
+	}
+	if c.HealthCheck != nil {
+		if err := c.HealthCheck.validate(); err != nil {
+			return errors.Wrap(err, "invalid healthCheck")
+		}
+	}
+	return nil
+}
+
+// ValidateAll validates every component and ensures that components do not share names or services.
+func ValidateAll(cfgs []Config) error {
+	names := map[string]bool{}
+	services := map[string]bool{}
+	for i := range cfgs {
+		c := &cfgs[i]
+		if err := c.Validate(); err != nil {
+			return errors.Wrapf(err, "invalid component %s", c.Name)
+		}
+		if names[c.Name] {
+			return fmt.Errorf("component name %s is used more than once", c.Name)
+		}
+		names[c.Name] = true
+		if services[strings.ToLower(c.serviceName())] {
+			return fmt.Errorf("service %s of component %s is used by another component", c.serviceName(), c.Name)
+		}
+		services[strings.ToLower(c.serviceName())] = true
+	}
+	return nil
+}
+
+func (c *Config) serviceName() string {
+	if c.Service.Name != "" {
+		return c.Service.Name
+	}
+	return c.Name
+}
+
+func (c *Config) binary() string {
+	if c.Binary != "" {
+		return c.Binary
+	}
+	return c.Name + ".exe"
+}
+
+func (c *Config) retainedVersions() int {
+	if c.RetainedVersions > 0 {
+		return c.RetainedVersions
+	}
+	return defaultRetainedVersions
+}
+
+func (c *Config) displayName() string {
+	if c.Service.DisplayName != "" {
+		return c.Service.DisplayName
+	}
+	return c.Name
+}
+
+// envVars returns the environment of the service as sorted NAME=value pairs.
+func (c *Config) envVars() []string {
+	var vars []string
+	for name, value := range c.Service.Env {
+		vars = append(vars, name+"="+value)
+	}
+	sort.Strings(vars)
+	return vars
+}
+
+// capture returns the output capture of the service with the log file resolved, nil if the output is not captured.
+func (c *Config) capture(logDir string) *logs.CaptureConfig {
+	if c.Service.CaptureOutput == nil {
+		return nil
+	}
+	capture := *c.Service.CaptureOutput
+	if capture.File == "" {
+		capture.File = filepath.Join(logDir, c.Name+".log")
+	}
+	return &capture
+}
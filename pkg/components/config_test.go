@@ -0,0 +1,95 @@
+package components
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rancher/wins/pkg/logs"
+)
+
+func TestValidateAll(t *testing.T) {
+	disabled := false
+	artifact := Artifact{URL: "https://example.com/exporter-%s.zip", Version: "v0.25.1"}
+
+	testCases := []struct {
+		name  string
+		cfgs  []Config
+		valid bool
+	}{
+		{
+			name:  "valid",
+			cfgs:  []Config{{Name: "windows-exporter", Artifact: artifact}, {Name: "fluent-bit", Artifact: artifact}},
+			valid: true,
+		},
+		{
+			name:  "disabled without artifact",
+			cfgs:  []Config{{Name: "windows-exporter", Enabled: &disabled}},
+			valid: true,
+		},
+		{
+			name: "invalid name",
+			cfgs: []Config{{Name: "Windows Exporter", Artifact: artifact}},
+		},
+		{
+			name: "missing version",
+			cfgs: []Config{{Name: "windows-exporter", Artifact: Artifact{URL: artifact.URL}}},
+		},
+		{
+			name: "duplicate name",
+			cfgs: []Config{{Name: "windows-exporter", Artifact: artifact}, {Name: "windows-exporter", Artifact: artifact}},
+		},
+		{
+			name: "duplicate service",
+			cfgs: []Config{
+				{Name: "windows-exporter", Artifact: artifact},
+				{Name: "exporter", Artifact: artifact, Service: Service{Name: "Windows-Exporter"}},
+			},
+		},
+		{
+			name: "invalid recovery",
+			cfgs: []Config{{Name: "windows-exporter", Artifact: artifact, Service: Service{Recovery: &Recovery{RestartDelay: "soon"}}}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateAll(tc.cfgs)
+			if tc.valid && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestConfigDefaults(t *testing.T) {
+	cfg := &Config{
+		Name:     "windows-exporter",
+		Artifact: Artifact{URL: "https://example.com/exporter-%s.zip", Version: "v0.25.1"},
+		Service: Service{
+			Env:           map[string]string{"b": "2", "a": "1"},
+			Recovery:      &Recovery{ResetPeriod: "1h"},
+			CaptureOutput: &logs.CaptureConfig{},
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if cfg.binary() != "windows-exporter.exe" || cfg.serviceName() != "windows-exporter" {
+		t.Errorf("unexpected defaults, binary %s, service %s", cfg.binary(), cfg.serviceName())
+	}
+	if r := cfg.Service.Recovery; r.restartDelay != 10*time.Second || r.resetPeriod != time.Hour {
+		t.Errorf("unexpected recovery %+v", r)
+	}
+	if env := cfg.envVars(); !reflect.DeepEqual(env, []string{"a=1", "b=2"}) {
+		t.Errorf("expected sorted environment variables, got %v", env)
+	}
+	if capture := cfg.capture(`c:\logs`); capture.File != filepath.Join(`c:\logs`, "windows-exporter.log") {
+		t.Errorf("expected the output to be captured in the log directory, got %s", capture.File)
+	}
+}
@@ -0,0 +1,144 @@
+package components
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	currentName  = "current"
+	manifestName = "component.json"
+	// wrapperName is the copy of the wins binary that runs components whose output is captured. Every version
+	// has its own copy, so that the wins binary is never in use by a component and can be upgraded.
+	wrapperName = "svc-wrap.exe"
+)
+
+// layout is where a component is installed. Every version is kept in its own directory, and the
+// service runs the binary through the current pointer, a symbolic link to one of the version directories:
+//
+//	<root>/<name>/<version>/<binary>
+//	<root>/<name>/<version>/svc-wrap.exe (if the output is captured)
+//	<root>/<name>/current -> <version>
+type layout struct {
+	dir    string
+	binary string
+}
+
+func newLayout(root string, cfg *Config) layout {
+	return layout{dir: filepath.Join(root, cfg.Name), binary: cfg.binary()}
+}
+
+func (l layout) versionDir(version string) string {
+	return filepath.Join(l.dir, version)
+}
+
+// versionBinary is where the binary of a version is installed.
+func (l layout) versionBinary(version string) string {
+	return filepath.Join(l.versionDir(version), l.binary)
+}
+
+// binaryPath is the path of the binary through the current pointer, which the service runs.
+func (l layout) binaryPath() string {
+	return filepath.Join(l.dir, currentName, l.binary)
+}
+
+// versionWrapper is where the svc-wrap copy of the wins binary of a version is installed.
+func (l layout) versionWrapper(version string) string {
+	return filepath.Join(l.versionDir(version), wrapperName)
+}
+
+// wrapperPath is the path of the svc-wrap copy of the wins binary through the current pointer.
+func (l layout) wrapperPath() string {
+	return filepath.Join(l.dir, currentName, wrapperName)
+}
+
+func (l layout) manifestPath() string {
+	return filepath.Join(l.dir, manifestName)
+}
+
+// currentVersion returns the version the current pointer refers to, empty if there is none.
+func (l layout) currentVersion() (string, error) {
+	target, err := os.Readlink(filepath.Join(l.dir, currentName))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read the current version")
+	}
+	return filepath.Base(target), nil
+}
+
+// setCurrent points the current pointer to the version. The binary behind the pointer must not be
+// running, as the pointer is briefly missing while it is replaced.
+func (l layout) setCurrent(version string) error {
+	if _, err := os.Stat(l.versionBinary(version)); err != nil {
+		return errors.Wrapf(err, "version %s is not installed", version)
+	}
+
+	current := filepath.Join(l.dir, currentName)
+	if err := os.Remove(current); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to remove the current pointer")
+	}
+	// the relative target keeps the pointer valid if the install root is moved
+	return errors.Wrapf(os.Symlink(version, current), "failed to point the current version to %s", version)
+}
+
+// clearCurrent removes the current pointer, so that no version is installed.
+func (l layout) clearCurrent() error {
+	if err := os.Remove(filepath.Join(l.dir, currentName)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to remove the current pointer")
+	}
+	return nil
+}
+
+// prune removes the oldest version directories, keeping the current version and up to retain versions in total.
+func (l layout) prune(retain int) error {
+	current, err := l.currentVersion()
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return err
+	}
+
+	type version struct {
+		name    string
+		modTime int64
+	}
+	var versions []version
+	for _, e := range entries {
+		if !e.IsDir() || e.Type()&os.ModeSymlink != 0 || e.Name() == current {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		versions = append(versions, version{name: e.Name(), modTime: info.ModTime().UnixNano()})
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].modTime > versions[j].modTime
+	})
+
+	// the current version counts towards the retained versions
+	keep := retain
+	if current != "" {
+		keep--
+	}
+	for i, v := range versions {
+		if i < keep {
+			continue
+		}
+		logrus.Infof("Removing version %s of %s", v.name, filepath.Base(l.dir))
+		if err := os.RemoveAll(l.versionDir(v.name)); err != nil {
+			logrus.Warnf("could not remove %s: %v", l.versionDir(v.name), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package components
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLayoutPrune(t *testing.T) {
+	l := layout{dir: t.TempDir(), binary: "exporter.exe"}
+	start := time.Now().Add(-time.Hour)
+	for i, version := range []string{"v1", "v2", "v3", "v4"} {
+		if err := os.MkdirAll(l.versionDir(version), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(l.versionBinary(version), []byte(version), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		modTime := start.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(l.versionDir(version), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the current version is retained even though it is the oldest
+	if err := l.setCurrent("v1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if current, err := l.currentVersion(); err != nil || current != "v1" {
+		t.Fatalf("expected current version v1, got %q: %v", current, err)
+	}
+	if err := l.prune(2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for version, kept := range map[string]bool{"v1": true, "v2": false, "v3": false, "v4": true} {
+		_, err := os.Stat(l.versionDir(version))
+		if kept != (err == nil) {
+			t.Errorf("expected version %s to be kept: %t, got %v", version, kept, err)
+		}
+	}
+	if content, err := os.ReadFile(filepath.Join(l.dir, currentName, l.binary)); err != nil || string(content) != "v1" {
+		t.Errorf("expected the current pointer to refer to v1, got %q: %v", content, err)
+	}
+	if err := l.setCurrent("v2"); err == nil {
+		t.Error("expected an error pointing to a pruned version")
+	}
+
+	if err := l.clearCurrent(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if current, err := l.currentVersion(); err != nil || current != "" {
+		t.Errorf("expected no current version, got %q: %v", current, err)
+	}
+	if _, err := os.Stat(l.versionDir("v1")); err != nil {
+		t.Errorf("expected version v1 to be kept: %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package components
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rancher/wins/pkg/metrics"
+	"github.com/rancher/wins/pkg/scm"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultHealthCheckInterval = time.Minute
+	probeTimeout               = 5 * time.Second
+	// maxInstallBackoff is the longest delay between attempts to install a component that has no current version.
+	maxInstallBackoff = time.Hour
+
+	actionRestart   = "restart"
+	actionReinstall = "reinstall"
+)
+
+var (
+	interventions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "component",
+		Name:      "interventions_total",
+		Help:      "Number of times wins restarted or reinstalled a component, by component, action and reason.",
+	}, []string{"component", "action", "reason"})
+
+	healthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "component",
+		Name:      "healthy",
+		Help:      "Whether the last health check of a component succeeded.",
+	}, []string{"component"})
+)
+
+// HealthCheck configures how wins keeps a component running after it was installed.
+type HealthCheck struct {
+	// Interval between health checks, defaults to 1m.
+	Interval string `yaml:"interval" json:"interval,omitempty"`
+	// ProbePipe is a named pipe of the component that must accept connections, e.g. \\.\pipe\csi-proxy-filesystem-v1.
+	ProbePipe string `yaml:"probePipe" json:"probePipe,omitempty"`
+
+	interval time.Duration
+}
+
+func (h *HealthCheck) validate() error {
+	h.interval = defaultHealthCheckInterval
+	if h.Interval != "" {
+		d, err := time.ParseDuration(h.Interval)
+		if err != nil {
+			return errors.Wrapf(err, "invalid interval %s", h.Interval)
+		}
+		if d <= 0 {
+			return errors.New("interval must be positive")
+		}
+		h.interval = d
+	}
+	return nil
+}
+
+// Monitor periodically checks that the service of the component is running, that its binary has not been
+// changed since it was last installed and, if configured, that its named pipe accepts connections. It restarts
+// or reinstalls the component as needed until ctx is done.
+func (c *Component) Monitor(ctx context.Context) {
+	check := c.cfg.HealthCheck
+	if check == nil {
+		check = &HealthCheck{interval: defaultHealthCheckInterval}
+	}
+
+	logrus.Infof("Monitoring the health of %s every %s", c.cfg.Name, check.interval)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(check.interval):
+		}
+
+		if err := c.heal(ctx, check); err != nil {
+			healthy.WithLabelValues(c.cfg.Name).Set(0)
+			logrus.Errorf("%s is unhealthy: %v", c.cfg.Name, err)
+			continue
+		}
+		healthy.WithLabelValues(c.cfg.Name).Set(1)
+	}
+}
+
+// heal runs a single health check, restarting or reinstalling the component if it fails.
+func (c *Component) heal(ctx context.Context, check *HealthCheck) error {
+	exists, err := c.concierge.ServiceExists()
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return c.intervene(ctx, actionReinstall, "service missing", c.Enable)
+	}
+	owned, err := c.concierge.Owned()
+	if err != nil {
+		return err
+	}
+	if !owned {
+		return errors.Wrapf(errNotOwned, "the %s service is not monitored", c.cfg.serviceName())
+	}
+
+	// without a current version, e.g. after an upgrade that could not be rolled back, the service has no binary
+	// to restart, so the component is installed again
+	current, err := c.layout.currentVersion()
+	if err != nil {
+		return err
+	}
+	if current == "" {
+		if now := time.Now(); now.Before(c.nextInstall) {
+			return errors.Errorf("%s is not installed, the next attempt to install it is at %s", c.cfg.Name, c.nextInstall.Format(time.RFC3339))
+		}
+		err := c.intervene(ctx, actionReinstall, "not installed", c.Enable)
+		c.backoffInstall(check.interval, err)
+		return err
+	}
+
+	if c.expectedSum != "" {
+		sum, err := fileSHA256(c.binaryPath)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "could not hash %s", c.binaryPath)
+		}
+		if !strings.EqualFold(sum, c.expectedSum) {
+			logrus.Warnf("The %s binary changed, expected sha256 %s, got %s", c.cfg.Name, c.expectedSum, sum)
+			return c.intervene(ctx, actionReinstall, "binary changed", c.reinstall)
+		}
+	}
+
+	state, err := c.concierge.State()
+	if err != nil {
+		return err
+	}
+	switch state {
+	case scm.Running:
+	case scm.StartPending:
+		// give a starting service the time to reach Running before intervening
+		ctx, cancel := context.WithTimeout(ctx, serviceStateTimeout)
+		defer cancel()
+		return c.concierge.WaitForState(ctx, scm.Running)
+	default:
+		logrus.Warnf("The %s service is in state %s instead of running", c.cfg.Name, state)
+		return c.intervene(ctx, actionRestart, "service not running", c.restartService)
+	}
+
+	if check.ProbePipe != "" {
+		if err := probePipe(ctx, check.ProbePipe); err != nil {
+			logrus.Warnf("The %s pipe %s does not accept connections: %v", c.cfg.Name, check.ProbePipe, err)
+			return c.intervene(ctx, actionRestart, "api unreachable", c.restartService)
+		}
+	}
+	return nil
+}
+
+// backoffInstall doubles the delay before the next attempt to install the component after every failed
+// attempt, starting at interval and up to maxInstallBackoff, and resets it once an attempt succeeds.
+func (c *Component) backoffInstall(interval time.Duration, err error) {
+	if err == nil {
+		c.installFailures = 0
+		c.nextInstall = time.Time{}
+		return
+	}
+	delay := interval
+	for i := 0; i < c.installFailures && delay < maxInstallBackoff; i++ {
+		delay *= 2
+	}
+	c.installFailures++
+	c.nextInstall = time.Now().Add(min(delay, maxInstallBackoff))
+}
+
+// intervene logs, counts and runs a corrective action.
+func (c *Component) intervene(ctx context.Context, action, reason string, fn func(context.Context) error) error {
+	logrus.Infof("%s health check failed (%s), running %s", c.cfg.Name, reason, action)
+	interventions.WithLabelValues(c.cfg.Name, action, reason).Inc()
+	if err := fn(ctx); err != nil {
+		return errors.Wrapf(err, "%s after %s failed", action, reason)
+	}
+	logrus.Infof("%s %s after %s succeeded", c.cfg.Name, action, reason)
+	return nil
+}
+
+// restartService restarts the service together with the running services that depend on it.
+func (c *Component) restartService(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, serviceStateTimeout)
+	defer cancel()
+	return c.concierge.Restart(ctx)
+}
+
+// reinstall downloads the configured version again and restarts the service with it.
+func (c *Component) reinstall(ctx context.Context) error {
+	if err := c.stop(ctx); err != nil {
+		return err
+	}
+	if err := c.install(); err != nil {
+		return err
+	}
+	if err := c.layout.setCurrent(c.cfg.Version); err != nil {
+		return err
+	}
+	if err := c.start(ctx); err != nil {
+		return err
+	}
+	return c.installed()
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,178 @@
+package components
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rancher/wins/pkg/scm"
+)
+
+func TestHeal(t *testing.T) {
+	type testCase struct {
+		name string
+		// setup prepares the node and returns the monitored component
+		setup     func(t *testing.T, env *testEnv) *Component
+		probePipe string
+		// starts is the number of times the service is expected to have been started after the health check
+		starts int
+		check  func(t *testing.T, env *testEnv, err error)
+	}
+
+	testCases := []testCase{
+		{
+			name: "healthy",
+			setup: func(t *testing.T, env *testEnv) *Component {
+				return env.install("v1")
+			},
+			starts: 1,
+		},
+		{
+			name: "service missing",
+			setup: func(t *testing.T, env *testEnv) *Component {
+				return env.component(env.config("v1"))
+			},
+			starts: 1,
+		},
+		{
+			name: "service not owned",
+			setup: func(t *testing.T, env *testEnv) *Component {
+				env.addService(`c:\program files\exporter\exporter.exe`, scm.Stopped)
+				return env.component(env.config("v1"))
+			},
+			check: func(t *testing.T, env *testEnv, err error) {
+				if !errors.Is(err, errNotOwned) {
+					t.Errorf("expected errNotOwned, got %v", err)
+				}
+			},
+		},
+		{
+			name: "not installed",
+			setup: func(t *testing.T, env *testEnv) *Component {
+				env.addService(env.manager.layout.binaryPath(), scm.Stopped)
+				c := env.component(env.config("v1"))
+				if err := c.concierge.MarkOwned(); err != nil {
+					t.Fatal(err)
+				}
+				return c
+			},
+			starts: 1,
+		},
+		{
+			name: "binary changed",
+			setup: func(t *testing.T, env *testEnv) *Component {
+				c := env.install("v1")
+				if err := os.WriteFile(env.manager.layout.versionBinary("v1"), []byte("MZchanged"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return c
+			},
+			starts: 2,
+			check: func(t *testing.T, env *testEnv, err error) {
+				if content, _ := os.ReadFile(env.manager.layout.binaryPath()); string(content) != "MZv1" {
+					t.Errorf("expected the binary to be reinstalled, got %q", content)
+				}
+			},
+		},
+		{
+			name: "service stopped",
+			setup: func(t *testing.T, env *testEnv) *Component {
+				c := env.install("v1")
+				if err := c.stop(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+				return c
+			},
+			starts: 2,
+		},
+		{
+			name: "service starting",
+			setup: func(t *testing.T, env *testEnv) *Component {
+				c := env.install("v1")
+				if err := c.stop(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+				env.manager.PendingQueries = 2
+				s, err := env.manager.Fake.OpenService(testService)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := s.Start(); err != nil {
+					t.Fatal(err)
+				}
+				return c
+			},
+			starts: 2,
+		},
+		{
+			name: "pipe unreachable",
+			setup: func(t *testing.T, env *testEnv) *Component {
+				return env.install("v1")
+			},
+			probePipe: `\\.\pipe\wins-test-missing`,
+			starts:    2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			c := tc.setup(t, env)
+
+			err := c.heal(context.Background(), &HealthCheck{ProbePipe: tc.probePipe, interval: time.Minute})
+			if tc.check != nil {
+				tc.check(t, env, err)
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			s := env.service()
+			if s.Starts != tc.starts {
+				t.Errorf("expected %d starts, got %d", tc.starts, s.Starts)
+			}
+			if tc.starts > 0 && s.Status.State != scm.Running {
+				t.Errorf("expected the service to be running, got %s", s.Status.State)
+			}
+		})
+	}
+}
+
+func TestHealInstallBackoff(t *testing.T) {
+	env := newTestEnv(t)
+	env.addService(env.manager.layout.binaryPath(), scm.Stopped)
+	c := env.component(env.config("v1"))
+	if err := c.concierge.MarkOwned(); err != nil {
+		t.Fatal(err)
+	}
+	check := &HealthCheck{interval: time.Minute}
+
+	env.manager.failing = "v1"
+	if err := c.heal(context.Background(), check); err == nil {
+		t.Fatal("expected the installation to fail")
+	}
+	if c.nextInstall.Before(time.Now().Add(59 * time.Second)) {
+		t.Errorf("expected the next installation not before an interval, got %s", c.nextInstall)
+	}
+
+	// the installation is not attempted again before the backoff expired
+	if err := c.heal(context.Background(), check); err == nil {
+		t.Fatal("expected the component to be reported as not installed")
+	}
+	if s := env.service(); s.Starts != 1 {
+		t.Errorf("expected a single attempt, got %d starts", s.Starts)
+	}
+
+	env.manager.failing = ""
+	c.nextInstall = time.Now()
+	if err := c.heal(context.Background(), check); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.installFailures != 0 || !c.nextInstall.IsZero() {
+		t.Errorf("expected the backoff to be reset, got %d failures", c.installFailures)
+	}
+	if env.currentVersion() != "v1" {
+		t.Errorf("expected current version v1, got %s", env.currentVersion())
+	}
+}
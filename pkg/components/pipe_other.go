@@ -0,0 +1,14 @@
+//go:build !windows
+
+package components
+
+import (
+	"context"
+
+	"github.com/rancher/wins/pkg/scm"
+)
+
+// probePipe fails with scm.ErrUnsupported, as there are no named pipes.
+func probePipe(context.Context, string) error {
+	return scm.ErrUnsupported
+}
@@ -0,0 +1,19 @@
+package components
+
+import (
+	"context"
+
+	"github.com/Microsoft/go-winio"
+)
+
+// probePipe reports whether the named pipe accepts connections.
+func probePipe(ctx context.Context, pipe string) error {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	conn, err := winio.DialPipeContext(ctx, pipe)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -1,32 +1,49 @@
 package concierge
 
 import (
-	"fmt"
+	"context"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/rancher/wins/pkg/scm"
+	"github.com/rancher/wins/pkg/services"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/sys/windows"
-	"golang.org/x/sys/windows/registry"
-	"golang.org/x/sys/windows/svc"
-	"golang.org/x/sys/windows/svc/mgr"
 )
 
+// ownerValue is the registry value of the service key that records which component created the service.
+const ownerValue = "ManagedBy"
+
 type Config struct {
 	Args        []string
 	Description string
 	DisplayName string
 	EnvVars     []string
-	registryKey string
+	// Owner is recorded on services created by the Concierge, so that only those services are ever removed.
+	Owner string
+	// RecoveryActions default to a single restart after 10 seconds.
+	RecoveryActions []scm.RecoveryAction
+	// RecoveryResetPeriod is the number of seconds without failures after which the failure count is reset.
+	RecoveryResetPeriod uint32
+	// StartType defaults to scm.StartAutomatic.
+	StartType uint32
 }
 
 type Concierge struct {
-	name string
-	path string
-	cfg  *Config
+	name    string
+	path    string
+	cfg     *Config
+	manager scm.Manager
 }
 
 // New creates a new Concierge for managing a Windows Service.
 func New(name, path string, cfg *Config) (*Concierge, error) {
+	return NewWithManager(scm.System(), name, path, cfg)
+}
+
+// NewWithManager creates a new Concierge that manages the Windows Service through the manager.
+func NewWithManager(manager scm.Manager, name, path string, cfg *Config) (*Concierge, error) {
 	if name == "" {
 		return nil, errors.New("name isn't set and can't be empty")
 	}
@@ -38,18 +55,16 @@ func New(name, path string, cfg *Config) (*Concierge, error) {
 		return nil, errors.New("cfg is nil, please provide at least an empty config")
 	}
 
-	cfg.registryKey = fmt.Sprintf(`SYSTEM\CurrentControlSet\Services\%s`, name)
-
 	return &Concierge{
-		name: name,
-		path: path,
-		cfg:  cfg,
+		name:    name,
+		path:    path,
+		cfg:     cfg,
+		manager: manager,
 	}, nil
 }
 
-// Enable will start the Windows Service. If the service doesn't exist it will create it.
-func (c *Concierge) Enable() error {
-	var service *mgr.Service
+// Enable will start the Windows Service and wait until it is running. If the service doesn't exist it will create it.
+func (c *Concierge) Enable(ctx context.Context) error {
 	ok, err := c.ServiceExists()
 	if err != nil {
 		return errors.Wrap(err, "error checking if the service exists")
@@ -60,158 +75,284 @@ func (c *Concierge) Enable() error {
 		}
 	}
 
-	if service, err = c.fetchService(); err != nil {
+	service, err := c.fetchService()
+	if err != nil {
 		return errors.Wrap(err, "error fetching the service")
 	}
 	defer service.Close()
 
-	return service.Start()
+	return service.Start(ctx)
 }
 
-// Disable will stop the Windows Service.
-func (c *Concierge) Disable() error {
-	var service *mgr.Service
-	ok, err := c.ServiceExists()
+// Disable will stop the Windows Service and wait until it is stopped.
+func (c *Concierge) Disable(ctx context.Context) error {
+	service, err := c.fetchService()
 	if err != nil {
-		return errors.Wrap(err, "error checking if the service exists")
-	}
-	if !ok {
-		return errors.Errorf("service %s not found", c.path)
+		return errors.Wrap(err, "error fetching the service")
 	}
+	defer service.Close()
 
-	if service, err = c.fetchService(); err != nil {
+	return service.Stop(ctx)
+}
+
+// Restart stops and starts the Windows Service and waits until it is running. Running services that depend on it
+// are stopped before and started again after it.
+func (c *Concierge) Restart(ctx context.Context) error {
+	service, err := c.fetchService()
+	if err != nil {
 		return errors.Wrap(err, "error fetching the service")
 	}
 	defer service.Close()
 
-	if _, err := service.Control(svc.Stop); err != nil {
-		return errors.Wrap(err, "error stopping the service")
-	}
-	return nil
+	return service.Restart(ctx)
 }
 
 // CreateService configures the Windows service correctly, returning the service.
 func (c *Concierge) CreateService() error {
-	m, err := mgr.Connect()
+	service, err := services.Create(c.manager, c.name, c.path, scm.Config{
+		ServiceType:  scm.ServiceWin32OwnProcess,
+		StartType:    c.startType(),
+		ErrorControl: scm.ErrorNormal,
+		Description:  c.cfg.Description,
+		DisplayName:  c.cfg.DisplayName,
+	}, c.cfg.Args...)
 	if err != nil {
-		return errors.Wrap(err, "could not open SCM")
+		return err
 	}
-	defer m.Disconnect()
+	defer service.Close()
 
-	service, err := m.CreateService(c.name, c.path, mgr.Config{
-		ServiceType:    windows.SERVICE_WIN32_OWN_PROCESS,
-		StartType:      mgr.StartAutomatic,
-		ErrorControl:   mgr.ErrorNormal,
-		BinaryPathName: c.path,
-		Description:    c.cfg.Description,
-		DisplayName:    c.cfg.DisplayName,
-	}, c.cfg.Args...)
+	if err := c.registerEnvVars(service); err != nil {
+		return err
+	}
+	if c.cfg.Owner != "" {
+		if err := service.SetValue(ownerValue, c.cfg.Owner); err != nil {
+			return errors.Wrap(err, "error recording the service owner")
+		}
+	}
 
-	defer service.Close()
+	return service.SetRecoveryActions(c.recoveryActions(), c.cfg.RecoveryResetPeriod)
+}
+
+// Changes are the differences between the live service and the Config that Reconcile applied.
+type Changes struct {
+	// Fields are the names of the settings that were changed.
+	Fields []string
+	// RestartRequired is set if a running service has to be restarted for the changes to take effect.
+	RestartRequired bool
+}
 
+// Changed reports whether any setting was changed.
+func (c Changes) Changed() bool {
+	return len(c.Fields) > 0
+}
+
+func (c *Changes) add(field string, restart bool) {
+	c.Fields = append(c.Fields, field)
+	c.RestartRequired = c.RestartRequired || restart
+}
+
+// Reconcile compares the live configuration of the existing service and its environment variables with the
+// Config and applies only the differences. The command line and the environment only take effect after a restart.
+func (c *Concierge) Reconcile() (Changes, error) {
+	var changes Changes
+
+	service, err := c.fetchService()
 	if err != nil {
-		return errors.Wrap(err, "error creating the service")
+		return changes, errors.Wrap(err, "error fetching the service")
 	}
+	defer service.Close()
 
-	recoveryActions := []mgr.RecoveryAction{
-		{
-			Type:  mgr.ServiceRestart,
-			Delay: 10000,
-		},
+	current := &service.Config
+	if binaryPath := scm.JoinCommandLine(c.path, c.cfg.Args...); current.BinaryPathName != binaryPath {
+		current.BinaryPathName = binaryPath
+		changes.add("command line", true)
 	}
-	if err := c.registerEnvVars(); err != nil {
-		return err
+	if displayName := c.displayName(); current.DisplayName != displayName {
+		current.DisplayName = displayName
+		changes.add("display name", false)
+	}
+	if current.Description != c.cfg.Description {
+		current.Description = c.cfg.Description
+		changes.add("description", false)
+	}
+	if startType := c.startType(); current.StartType != startType {
+		current.StartType = startType
+		changes.add("start type", false)
+	}
+	if changes.Changed() {
+		if err := service.UpdateConfig(); err != nil {
+			return Changes{}, errors.Wrap(err, "error updating the service config")
+		}
 	}
 
-	return service.SetRecoveryActions(recoveryActions, 0)
-}
+	envChanged, err := c.updateEnvVars(service)
+	if envChanged {
+		changes.add("environment", true)
+	}
+	if err != nil {
+		return changes, err
+	}
 
-// Delete removes the service and any registry keys.
-func (c *Concierge) Delete() error {
-	var service *mgr.Service
-	ok, err := c.ServiceExists()
+	recoveryChanged, err := c.updateRecoveryActions(service)
+	if recoveryChanged {
+		changes.add("recovery actions", false)
+	}
 	if err != nil {
-		return errors.Wrap(err, "error checking if the service exists")
+		return changes, err
 	}
-	if !ok {
-		return errors.Errorf("service %s not found", c.path)
+
+	if changes.Changed() {
+		logrus.Infof("updated the %s of %s", strings.Join(changes.Fields, ", "), c.name)
 	}
+	return changes, nil
+}
 
-	if service, err = c.fetchService(); err != nil {
-		return errors.Wrap(err, "error fetching the service")
+// Delete stops and deletes the service and waits until it is removed, then removes its registry key and the
+// paths. The report lists what was removed and what failed, nothing is removed if the service could not be deleted.
+func (c *Concierge) Delete(ctx context.Context, paths ...string) *services.Report {
+	return services.Remove(ctx, c.manager, c.name, services.Cleanup{Paths: paths})
+}
+
+// Owned reports whether the service was created by the Owner of the Config.
+func (c *Concierge) Owned() (bool, error) {
+	if c.cfg.Owner == "" {
+		return false, nil
+	}
+
+	service, err := c.fetchService()
+	if err != nil {
+		return false, errors.Wrap(err, "error fetching the service")
 	}
 	defer service.Close()
 
-	return service.Delete()
+	owner, err := service.Value(ownerValue)
+	if errors.Is(err, services.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "error reading the service owner")
+	}
+	return owner == c.cfg.Owner, nil
 }
 
-// ServiceExists retrieves the Windows service if exists.
-func (c *Concierge) ServiceExists() (bool, error) {
-	m, err := mgr.Connect()
-	if err != nil {
-		return false, errors.Wrap(err, "could not open SCM")
+// MarkOwned records the Owner of the Config on the service.
+func (c *Concierge) MarkOwned() error {
+	if c.cfg.Owner == "" {
+		return nil
 	}
-	defer func(m *mgr.Mgr) {
-		_ = m.Disconnect()
-	}(m)
 
-	services, err := m.ListServices()
+	service, err := c.fetchService()
 	if err != nil {
-		return false, errors.Wrap(err, "could not list services")
+		return errors.Wrap(err, "error fetching the service")
 	}
+	defer service.Close()
 
-	for _, service := range services {
-		if service == c.name {
-			return true, nil
-		}
+	return errors.Wrap(service.SetValue(ownerValue, c.cfg.Owner), "error recording the service owner")
+}
+
+// CommandLine returns the executable and arguments the service is configured to run.
+func (c *Concierge) CommandLine() ([]string, error) {
+	service, err := c.fetchService()
+	if err != nil {
+		return nil, errors.Wrap(err, "error fetching the service")
 	}
+	defer service.Close()
+
+	return scm.SplitCommandLine(service.Config.BinaryPathName), nil
+}
 
-	return false, nil
+// ServiceExists reports whether the Windows service exists.
+func (c *Concierge) ServiceExists() (bool, error) {
+	return services.Exists(c.manager, c.name)
 }
 
 // State gets the state of the service. Examples are stopped, running, etc.
-func (c *Concierge) State() (svc.State, error) {
+func (c *Concierge) State() (scm.State, error) {
 	service, err := c.fetchService()
 	if err != nil {
-		return svc.State(windows.SERVICE_NO_CHANGE), errors.Wrap(err, "error opening the service")
+		return 0, errors.Wrap(err, "error opening the service")
 	}
 	defer service.Close()
 
-	status, err := service.Query()
+	return service.State()
+}
+
+// WaitForState polls the service until it reaches the state, the returned error matches services.ErrTimeout
+// if ctx is done first.
+func (c *Concierge) WaitForState(ctx context.Context, state scm.State) error {
+	service, err := c.fetchService()
 	if err != nil {
-		return svc.State(windows.SERVICE_NO_CHANGE), errors.Wrap(err, "error querying the service")
+		return errors.Wrap(err, "error opening the service")
 	}
-	return status.State, nil
+	defer service.Close()
+
+	return service.WaitForState(ctx, state)
 }
 
-// fetchService retrieves the Windows service.
-func (c *Concierge) fetchService() (*mgr.Service, error) {
-	m, err := mgr.Connect()
+// fetchService opens the Windows service, the caller has to close it.
+func (c *Concierge) fetchService() (*services.Service, error) {
+	return services.Open(c.manager, c.name)
+}
+
+// updateEnvVars replaces the environment variables of the service if they differ from the Config.
+func (c *Concierge) updateEnvVars(service *services.Service) (bool, error) {
+	current, err := service.Environment()
 	if err != nil {
-		return nil, errors.Wrap(err, "could not open SCM")
+		return false, errors.Wrap(err, "error reading the service environment")
+	}
+	if slices.Equal(current, c.cfg.EnvVars) {
+		return false, nil
 	}
-	defer m.Disconnect()
 
-	service, err := m.OpenService(c.name)
+	logrus.Infof("updating the environment variables of %s", c.name)
+	return true, errors.Wrap(service.SetEnvironment(c.cfg.EnvVars), "error setting the service environment")
+}
+
+// updateRecoveryActions replaces the recovery actions of the service if they differ from the Config.
+func (c *Concierge) updateRecoveryActions(service *services.Service) (bool, error) {
+	current, resetPeriod, err := service.RecoveryActions()
 	if err != nil {
-		return nil, errors.Wrap(err, "could not open service")
+		return false, err
+	}
+	if slices.Equal(current, c.recoveryActions()) && resetPeriod == c.cfg.RecoveryResetPeriod {
+		return false, nil
+	}
+	return true, errors.Wrap(service.SetRecoveryActions(c.recoveryActions(), c.cfg.RecoveryResetPeriod), "error setting the service recovery actions")
+}
+
+func (c *Concierge) recoveryActions() []scm.RecoveryAction {
+	if len(c.cfg.RecoveryActions) > 0 {
+		return c.cfg.RecoveryActions
 	}
+	return []scm.RecoveryAction{
+		{
+			Type:  scm.ServiceRestart,
+			Delay: 10 * time.Second,
+		},
+	}
+}
 
-	return service, nil
+func (c *Concierge) startType() uint32 {
+	if c.cfg.StartType != 0 {
+		return c.cfg.StartType
+	}
+	return scm.StartAutomatic
 }
 
-// registerEnvVars creates a registry key for the service to set environment variables.
-func (c *Concierge) registerEnvVars() error {
+// displayName is the display name of the service, the service manager uses the name of the service if none is set.
+func (c *Concierge) displayName() string {
+	if c.cfg.DisplayName != "" {
+		return c.cfg.DisplayName
+	}
+	return c.name
+}
+
+// registerEnvVars sets the environment variables of the service.
+func (c *Concierge) registerEnvVars(service *services.Service) error {
 	if len(c.cfg.EnvVars) == 0 {
 		logrus.Infof("skipping environment variable configuration for %s, none are provided", c.name)
 		return nil
 	}
 
-	k, err := registry.OpenKey(registry.LOCAL_MACHINE, c.cfg.registryKey, registry.WRITE)
-	if err != nil {
-		return errors.Wrap(err, "error opening registry key")
-	}
-	defer k.Close()
-
-	return k.SetStringsValue("Environment", c.cfg.EnvVars)
+	return service.SetEnvironment(c.cfg.EnvVars)
 }
@@ -0,0 +1,110 @@
+package concierge
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/rancher/wins/pkg/scm"
+)
+
+func TestEnable(t *testing.T) {
+	fake := scm.NewFake()
+	c, err := NewWithManager(fake, "exporter", `c:\bin\exporter.exe`, &Config{
+		Args:    []string{"--port", "9182"},
+		EnvVars: []string{"A=1"},
+		Owner:   "rancher-wins",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := c.Enable(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s, ok := fake.Service("exporter")
+	if !ok {
+		t.Fatal("expected the service to be created")
+	}
+	if s.Status.State != scm.Running || s.Config.StartType != scm.StartAutomatic {
+		t.Errorf("expected a running automatic service, got state %s and start type %d", s.Status.State, s.Config.StartType)
+	}
+	if s.Config.BinaryPathName != `c:\bin\exporter.exe --port 9182` || !reflect.DeepEqual(s.Environment, []string{"A=1"}) {
+		t.Errorf("unexpected command line %s or environment %v", s.Config.BinaryPathName, s.Environment)
+	}
+	if owned, err := c.Owned(); err != nil || !owned {
+		t.Errorf("expected the service to be owned, got %t: %v", owned, err)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	fake := scm.NewFake()
+	cfg := &Config{Args: []string{"--port", "9182"}, DisplayName: "Exporter"}
+	c, err := NewWithManager(fake, "exporter", `c:\bin\exporter.exe`, cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.CreateService(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	changes, err := c.Reconcile()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if changes.Changed() {
+		t.Errorf("expected no changes for a service that matches the config, got %v", changes.Fields)
+	}
+
+	testCases := []struct {
+		name    string
+		update  func(cfg *Config)
+		fields  []string
+		restart bool
+	}{
+		{
+			name:    "arguments",
+			update:  func(cfg *Config) { cfg.Args = []string{"--port", "9183"} },
+			fields:  []string{"command line"},
+			restart: true,
+		},
+		{
+			name: "display name and description",
+			update: func(cfg *Config) {
+				cfg.DisplayName = "Windows Exporter"
+				cfg.Description = "Exports metrics"
+			},
+			fields: []string{"display name", "description"},
+		},
+		{
+			name:    "environment",
+			update:  func(cfg *Config) { cfg.EnvVars = []string{"A=1"} },
+			fields:  []string{"environment"},
+			restart: true,
+		},
+		{
+			name: "start type and recovery",
+			update: func(cfg *Config) {
+				cfg.StartType = scm.StartManual
+				cfg.RecoveryResetPeriod = 3600
+			},
+			fields: []string{"start type", "recovery actions"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.update(cfg)
+			changes, err := c.Reconcile()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(changes.Fields, tc.fields) || changes.RestartRequired != tc.restart {
+				t.Errorf("expected changes %v with restart %t, got %v with restart %t", tc.fields, tc.restart, changes.Fields, changes.RestartRequired)
+			}
+			if changes, _ := c.Reconcile(); changes.Changed() {
+				t.Errorf("expected the changes to be applied, got %v again", changes.Fields)
+			}
+		})
+	}
+}
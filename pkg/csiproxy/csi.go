@@ -1,184 +1,111 @@
 package csiproxy
 
 import (
-	"archive/tar"
-	"compress/gzip"
-	"crypto/tls"
 	"fmt"
-	"io"
-	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
 
-	"github.com/sirupsen/logrus"
-
 	"github.com/pkg/errors"
-	"github.com/rancher/wins/pkg/concierge"
-	winstls "github.com/rancher/wins/pkg/tls"
+	"github.com/rancher/wins/pkg/components"
 )
 
 const (
+	// ComponentName is the name of the component CSI Proxy is managed as.
+	ComponentName = "csi-proxy"
+
 	exeName     = "csi-proxy.exe"
 	serviceName = "csiproxy"
+
+	defaultLogFile = `\etc\rancher\wins\csi-proxy.log`
 )
 
 // Config is the CSI Proxy config settings
 type Config struct {
-	URL         string `yaml:"url" json:"url"`
-	Version     string `yaml:"version" json:"version"`
+	// Enabled defaults to true. If it is false, CSI Proxy is removed from the node like when the section is missing.
+	Enabled             *bool `yaml:"enabled" json:"enabled,omitempty"`
+	components.Artifact `yaml:",inline"`
+	// KubeletPath is the kubelet directory in the host file system, passed to CSI Proxy as -kubelet-path.
 	KubeletPath string `yaml:"kubeletPath" json:"kubeletPath"`
+	// LogFile defaults to \etc\rancher\wins\csi-proxy.log.
+	LogFile string `yaml:"logFile" json:"logFile,omitempty"`
+	// Verbosity is the klog verbosity of CSI Proxy, passed as -v.
+	Verbosity *int `yaml:"verbosity" json:"verbosity,omitempty"`
+	// ExtraArgs are appended to the CSI Proxy command line, e.g. to select API group versions.
+	ExtraArgs []string `yaml:"extraArgs" json:"extraArgs,omitempty"`
+	// Env is set as environment variables of the CSI Proxy service.
+	Env map[string]string `yaml:"env" json:"env,omitempty"`
+	// HealthCheck configures the monitoring of CSI Proxy after it was installed.
+	HealthCheck *components.HealthCheck `yaml:"healthCheck" json:"healthCheck,omitempty"`
 }
 
-// Validate ensures that the configuration for CSI Proxy is correct if provided.
-func (c *Config) validate() error {
-	if strings.TrimSpace(c.URL) == "" {
-		return errors.New("CSI Proxy URL cannot be empty")
-	}
-
-	if strings.TrimSpace(c.Version) == "" {
-		return errors.New("CSI Proxy version cannot be empty")
-	}
+// IsEnabled reports whether CSI Proxy should be installed, a missing config means it should be removed.
+func (c *Config) IsEnabled() bool {
+	return c != nil && (c.Enabled == nil || *c.Enabled)
+}
 
+// validate ensures that the settings specific to CSI Proxy are correct, the artifact is
+// validated with the component.
+func (c *Config) validate() error {
 	if strings.TrimSpace(c.KubeletPath) == "" {
 		return errors.New("kubelet path cannot be empty")
 	}
-	return nil
-}
-
-// Proxy is for creating and retrieving the Windows Service
-type Proxy struct {
-	cfg         *Config
-	tlsCfg      *winstls.Config
-	serviceName string
-	binaryName  string
-	binaryPath  string
-	concierge   *concierge.Concierge
-}
-
-// New creates a new Proxy struct
-func New(cfg *Config, tlsCfg *winstls.Config) (*Proxy, error) {
-	if err := cfg.validate(); err != nil {
-		return nil, err
-	}
-
-	cwd, err := os.Getwd()
-	if err != nil {
-		return nil, err
-	}
-
-	config := concierge.Config{
-		Args:        []string{"-windows-service", "-log_file=\\etc\\rancher\\wins\\csi-proxy.log", "-logtostderr=false"},
-		Description: "Manages the Kubernetes CSI Proxy application.",
-		DisplayName: "CSI Proxy",
-		EnvVars:     nil,
-	}
 
-	service, err := concierge.New(serviceName, filepath.Join(cwd, exeName), &config)
-	if err != nil {
-		return nil, err
+	if c.Verbosity != nil && *c.Verbosity < 0 {
+		return errors.New("verbosity cannot be negative")
 	}
-
-	return &Proxy{
-		cfg:         cfg,
-		tlsCfg:      tlsCfg,
-		serviceName: serviceName,
-		binaryName:  exeName,
-		binaryPath:  filepath.Join(cwd, exeName),
-		concierge:   service,
-	}, nil
+	return nil
 }
 
-func (p *Proxy) Enable() error {
-	ok, err := p.concierge.ServiceExists()
-	if err != nil {
-		return err
+// Component returns the managed component that runs CSI Proxy. A missing or disabled config
+// results in a disabled component, so that a CSI Proxy installed by wins is removed.
+func (c *Config) Component() (*components.Config, error) {
+	enabled := c.IsEnabled()
+	component := &components.Config{
+		Name:    ComponentName,
+		Enabled: &enabled,
+		Binary:  exeName,
+		Service: components.Service{
+			Name:        serviceName,
+			DisplayName: "CSI Proxy",
+			Description: "Manages the Kubernetes CSI Proxy application.",
+		},
+		LogFiles:      []string{defaultLogFile},
+		LegacyInstall: true,
 	}
-	if !ok {
-		if p.tlsCfg != nil && p.tlsCfg.CertFilePath != "" {
-			// CSI Proxy does not need the certpool that is returned
-			_, err := p.tlsCfg.SetupGenericTLSConfigFromFile()
-			if err != nil {
-
-				return err
-			}
-		}
-		logrus.Infof("CSI Proxy is being downloaded.")
-		if err := p.download(); err != nil {
-			return err
-		}
-		logrus.Infof("CSI Proxy is being started.")
-		if err := p.concierge.Enable(); err != nil {
-			return err
-		}
+	if c == nil {
+		return component, nil
 	}
-	return nil
-}
-
-// download retrieves the CSI Proxy executable from the config settings.
-func (p *Proxy) download() error {
-	file, err := os.Create(p.binaryPath)
-	if err != nil {
-		return err
+	if c.LogFile != "" {
+		component.LogFiles = []string{c.LogFile}
 	}
-
-	defer func(file *os.File) {
-		_ = file.Close()
-	}(file)
-
-	client := http.Client{
-		CheckRedirect: func(r *http.Request, _ []*http.Request) error {
-			r.URL.Opaque = r.URL.Path
-			return nil
-		},
+	if !enabled {
+		return component, nil
 	}
 
-	// default to insecure which matches system-agent functionality
-	// if a proxy is set with the proper envvars, we will use it
-	// as long as the req does not match an entry in no_proxy env var
-	transport := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, Proxy: http.ProxyFromEnvironment}
-
-	if p.tlsCfg != nil && p.tlsCfg.Insecure != nil && !*p.tlsCfg.Insecure && p.tlsCfg.CertFilePath != "" {
-		transport.TLSClientConfig.InsecureSkipVerify = false
+	if err := c.validate(); err != nil {
+		return nil, err
 	}
+	component.Artifact = c.Artifact
+	component.Service.Args = c.args()
+	component.Service.Env = c.Env
+	component.HealthCheck = c.HealthCheck
+	return component, nil
+}
 
-	client.Transport = transport
-
-	defer client.CloseIdleConnections()
-
-	resp, err := client.Get(fmt.Sprintf(p.cfg.URL, p.cfg.Version))
-	if err != nil {
-		return err
+// args builds the command line of the CSI Proxy service.
+func (c *Config) args() []string {
+	logFile := c.LogFile
+	if logFile == "" {
+		logFile = defaultLogFile
 	}
 
-	defer func(Body io.ReadCloser) {
-		_ = Body.Close()
-	}(resp.Body)
-
-	gz, err := gzip.NewReader(resp.Body)
-	if err != nil {
-		return err
+	args := []string{
+		"-windows-service",
+		"-log_file=" + logFile,
+		"-logtostderr=false",
+		"-kubelet-path=" + c.KubeletPath,
 	}
-	defer func(gz *gzip.Reader) {
-		_ = gz.Close()
-	}(gz)
-
-	tr := tar.NewReader(gz)
-	for {
-		hdr, err := tr.Next()
-
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
-		}
-
-		if strings.Contains(hdr.Name, p.binaryName) {
-			if _, err := io.Copy(file, tr); err != nil {
-				return err
-			}
-		}
+	if c.Verbosity != nil {
+		args = append(args, fmt.Sprintf("-v=%d", *c.Verbosity))
 	}
-	return nil
+	return append(args, c.ExtraArgs...)
 }
@@ -0,0 +1,58 @@
+package csiproxy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestServiceArgs(t *testing.T) {
+	verbosity := 4
+	cfg := &Config{
+		KubeletPath: `c:\var\lib\kubelet`,
+		LogFile:     `c:\var\log\csi-proxy.log`,
+		Verbosity:   &verbosity,
+		ExtraArgs:   []string{"-disk-api-version=v1"},
+		Env:         map[string]string{"b": "2", "a": "1"},
+	}
+
+	expected := []string{
+		"-windows-service",
+		`-log_file=c:\var\log\csi-proxy.log`,
+		"-logtostderr=false",
+		`-kubelet-path=c:\var\lib\kubelet`,
+		"-v=4",
+		"-disk-api-version=v1",
+	}
+	if args := cfg.args(); !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected args %v, got %v", expected, args)
+	}
+}
+
+func TestComponent(t *testing.T) {
+	var missing *Config
+	c, err := missing.Component()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.IsEnabled() || c.Name != ComponentName || c.Service.Name != serviceName {
+		t.Errorf("expected a disabled %s component for a missing config, got %+v", ComponentName, c)
+	}
+
+	cfg := &Config{KubeletPath: `c:\var\lib\kubelet`, Env: map[string]string{"a": "1"}}
+	cfg.URL = "https://example.com/csi-proxy-%s.tar.gz"
+	cfg.Version = "v1.1.3"
+	if c, err = cfg.Component(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("expected a valid component, got %v", err)
+	}
+	if !reflect.DeepEqual(c.Service.Args, cfg.args()) || c.Binary != exeName {
+		t.Errorf("unexpected component %+v", c)
+	}
+
+	cfg.KubeletPath = ""
+	if _, err := cfg.Component(); err == nil {
+		t.Error("expected an error without a kubelet path")
+	}
+}
@@ -5,7 +5,11 @@
 )
 
 var (
-	AppVersion = "dev"
-	AppCommit  = "0000000"
-	ConfigPath = filepath.Join("c:/", "etc", "rancher", "wins", "config")
+	AppVersion      = "dev"
+	AppCommit       = "0000000"
+	ConfigPath      = filepath.Join("c:/", "etc", "rancher", "wins", "config")
+	AgentStatusPath = filepath.Join("c:/", "etc", "rancher", "wins", "agent-status.json")
+	PlanStagingDir  = filepath.Join("c:/", "etc", "rancher", "wins", "plans")
+	InstallRoot     = filepath.Join("c:/", "etc", "rancher", "wins", "components")
+	LogDir          = filepath.Join("c:/", "etc", "rancher", "wins", "logs")
 )
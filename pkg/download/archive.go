@@ -0,0 +1,200 @@
+package download
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Format is the format of a downloaded artifact.
+type Format string
+
+const (
+	FormatTarGz Format = "tar.gz"
+	FormatZip   Format = "zip"
+	// FormatExe is a bare Windows executable that is used as is.
+	FormatExe Format = "exe"
+)
+
+var magic = map[Format][]byte{
+	FormatTarGz: {0x1f, 0x8b},
+	FormatZip:   []byte("PK\x03\x04"),
+	FormatExe:   []byte("MZ"),
+}
+
+// contentTypes are the media types servers commonly send for the formats.
+var contentTypes = map[string]Format{
+	"application/gzip":                              FormatTarGz,
+	"application/x-gzip":                            FormatTarGz,
+	"application/x-gtar":                            FormatTarGz,
+	"application/x-compressed-tar":                  FormatTarGz,
+	"application/zip":                               FormatZip,
+	"application/x-zip-compressed":                  FormatZip,
+	"application/vnd.microsoft.portable-executable": FormatExe,
+	"application/x-msdownload":                      FormatExe,
+	"application/x-msdos-program":                   FormatExe,
+	"application/x-dosexec":                         FormatExe,
+}
+
+// ParseFormat validates a configured format, an empty format is detected from the artifact.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case "", FormatTarGz, FormatZip, FormatExe:
+		return f, nil
+	case "tgz":
+		return FormatTarGz, nil
+	default:
+		return "", fmt.Errorf("unsupported format %s, must be one of %s, %s or %s", s, FormatTarGz, FormatZip, FormatExe)
+	}
+}
+
+// DetectFormat determines the format of the artifact from its magic bytes. If they do not identify the format, the
+// content type of the response, which may be empty, and then the extension of name are used.
+func DetectFormat(artifact io.ReaderAt, name, contentType string) (Format, error) {
+	header := make([]byte, 4)
+	n, err := artifact.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	header = header[:n]
+	for _, f := range []Format{FormatTarGz, FormatZip, FormatExe} {
+		if bytes.HasPrefix(header, magic[f]) {
+			return f, nil
+		}
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		if f, ok := contentTypes[mediaType]; ok {
+			return f, nil
+		}
+	}
+
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return FormatTarGz, nil
+	case strings.HasSuffix(name, ".zip"):
+		return FormatZip, nil
+	case strings.HasSuffix(name, ".exe"):
+		return FormatExe, nil
+	}
+	return "", fmt.Errorf("could not detect the format of %s", name)
+}
+
+// ExtractFile writes the single entry of the artifact that matches entry to w. An entry with a
+// directory must match the path inside the archive exactly, otherwise the file name is compared.
+// It is an error if not exactly one entry matches. Bare executables are copied as they are.
+func ExtractFile(artifact *os.File, format Format, entry string, w io.Writer) error {
+	if _, err := artifact.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	switch format {
+	case FormatExe:
+		_, err := io.Copy(w, artifact)
+		return err
+	case FormatTarGz:
+		return extractTarGz(artifact, entry, w)
+	case FormatZip:
+		info, err := artifact.Stat()
+		if err != nil {
+			return err
+		}
+		return extractZip(artifact, info.Size(), entry, w)
+	default:
+		return fmt.Errorf("unsupported format %s", format)
+	}
+}
+
+func extractTarGz(r io.Reader, entry string, w io.Writer) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer func(gz *gzip.Reader) {
+		_ = gz.Close()
+	}(gz)
+
+	var matches []string
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg || !matchEntry(hdr.Name, entry) {
+			continue
+		}
+
+		matches = append(matches, hdr.Name)
+		if len(matches) > 1 {
+			return ambiguousEntry(entry, matches)
+		}
+		if _, err := io.Copy(w, tr); err != nil {
+			return err
+		}
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("archive does not contain %s", entry)
+	}
+	return nil
+}
+
+func extractZip(r io.ReaderAt, size int64, entry string, w io.Writer) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	var match *zip.File
+	var matches []string
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !matchEntry(f.Name, entry) {
+			continue
+		}
+		match = f
+		matches = append(matches, f.Name)
+	}
+	switch len(matches) {
+	case 0:
+		return fmt.Errorf("archive does not contain %s", entry)
+	case 1:
+	default:
+		return ambiguousEntry(entry, matches)
+	}
+
+	rc, err := match.Open()
+	if err != nil {
+		return errors.Wrapf(err, "could not open %s in the archive", match.Name)
+	}
+	defer func(rc io.ReadCloser) {
+		_ = rc.Close()
+	}(rc)
+	_, err = io.Copy(w, rc)
+	return err
+}
+
+func matchEntry(name, entry string) bool {
+	name = strings.TrimPrefix(path.Clean(strings.ReplaceAll(name, `\`, "/")), "/")
+	entry = strings.TrimPrefix(path.Clean(strings.ReplaceAll(entry, `\`, "/")), "/")
+	if strings.Contains(entry, "/") {
+		return strings.EqualFold(name, entry)
+	}
+	return strings.EqualFold(path.Base(name), entry)
+}
+
+func ambiguousEntry(entry string, matches []string) error {
+	return fmt.Errorf("archive contains more than one %s: %s, the path inside the archive must be configured", entry, strings.Join(matches, ", "))
+}
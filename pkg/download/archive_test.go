@@ -0,0 +1,132 @@
+package download
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tarGz(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zipArchive(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractFile(t *testing.T) {
+	single := map[string]string{"bin/csi-proxy.exe": "MZ proxy", "README.md": "docs"}
+	several := map[string]string{"amd64/csi-proxy.exe": "MZ amd64", "arm64/csi-proxy.exe": "MZ arm64"}
+
+	type test struct {
+		name        string
+		artifact    []byte
+		fileName    string
+		entry       string
+		format      Format
+		expected    string
+		errExpected bool
+	}
+
+	tests := []test{
+		{name: "tar.gz by file name", artifact: tarGz(t, single), fileName: "csi-proxy.tar.gz", entry: "csi-proxy.exe", format: FormatTarGz, expected: "MZ proxy"},
+		{name: "zip by file name", artifact: zipArchive(t, single), fileName: "csi-proxy.zip", entry: "csi-proxy.exe", format: FormatZip, expected: "MZ proxy"},
+		{name: "Bare executable", artifact: []byte("MZ proxy"), fileName: "download", entry: "csi-proxy.exe", format: FormatExe, expected: "MZ proxy"},
+		{name: "Exact path", artifact: tarGz(t, several), fileName: "csi-proxy.tar.gz", entry: "arm64/csi-proxy.exe", format: FormatTarGz, expected: "MZ arm64"},
+		{name: "Exact path in zip", artifact: zipArchive(t, several), fileName: "csi-proxy.zip", entry: "./amd64/csi-proxy.exe", format: FormatZip, expected: "MZ amd64"},
+		{name: "Ambiguous tar.gz entry", artifact: tarGz(t, several), fileName: "csi-proxy.tar.gz", entry: "csi-proxy.exe", format: FormatTarGz, errExpected: true},
+		{name: "Ambiguous zip entry", artifact: zipArchive(t, several), fileName: "csi-proxy.zip", entry: "csi-proxy.exe", format: FormatZip, errExpected: true},
+		{name: "Missing entry", artifact: tarGz(t, single), fileName: "csi-proxy.tar.gz", entry: "csi-proxy-api-gen.exe", format: FormatTarGz, errExpected: true},
+		{name: "Name containing the entry is not a match", artifact: tarGz(t, map[string]string{"csi-proxy.exe.sig": "sig"}), fileName: "a.tar.gz", entry: "csi-proxy.exe", format: FormatTarGz, errExpected: true},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "artifact")
+			if err := os.WriteFile(path, tst.artifact, 0644); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			format, err := DetectFormat(f, tst.fileName, "")
+			if err != nil {
+				t.Fatalf("unexpected error detecting the format: %v", err)
+			}
+			if format != tst.format {
+				t.Errorf("expected format %s, got %s", tst.format, format)
+			}
+
+			var out bytes.Buffer
+			err = ExtractFile(f, format, tst.entry, &out)
+			if err != nil && !tst.errExpected {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if err == nil && tst.errExpected {
+				t.Error("expected an error")
+			}
+			if !tst.errExpected && out.String() != tst.expected {
+				t.Errorf("expected %q, got %q", tst.expected, out.String())
+			}
+		})
+	}
+}
+
+func TestDetectFormatByExtension(t *testing.T) {
+	f := bytes.NewReader([]byte("??"))
+	if format, err := DetectFormat(f, "csi-proxy.TGZ", ""); err != nil || format != FormatTarGz {
+		t.Errorf("expected %s, got %s: %v", FormatTarGz, format, err)
+	}
+	if _, err := DetectFormat(f, "csi-proxy", "application/octet-stream"); err == nil {
+		t.Error("expected an error for unknown content without an extension")
+	}
+}
+
+func TestDetectFormatByContentType(t *testing.T) {
+	f := bytes.NewReader([]byte("??"))
+	if format, err := DetectFormat(f, "download.tar.gz", "application/zip; charset=binary"); err != nil || format != FormatZip {
+		t.Errorf("expected %s, got %s: %v", FormatZip, format, err)
+	}
+	// the magic bytes take precedence over the content type
+	if format, err := DetectFormat(bytes.NewReader([]byte("MZ")), "download", "application/zip"); err != nil || format != FormatExe {
+		t.Errorf("expected %s, got %s: %v", FormatExe, format, err)
+	}
+}
@@ -0,0 +1,223 @@
+package download
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultConnectTimeout = 30 * time.Second
+	DefaultTimeout        = 10 * time.Minute
+	DefaultAttempts       = 5
+	DefaultBackoff        = 2 * time.Second
+	maxBackoff            = time.Minute
+)
+
+// Client downloads artifacts over HTTP. Failed downloads are retried with an exponential backoff.
+type Client struct {
+	HTTP *http.Client
+	// Attempts is how often a download is tried before giving up.
+	Attempts int
+	// Backoff is the delay before the first retry, it doubles with every further retry.
+	Backoff time.Duration
+}
+
+// NewClient creates a Client with connect and total timeouts that uses the proxy of the environment.
+func NewClient(tlsConfig *tls.Config) *Client {
+	dialer := &net.Dialer{Timeout: DefaultConnectTimeout}
+	return &Client{
+		HTTP: &http.Client{
+			Timeout: DefaultTimeout,
+			CheckRedirect: func(r *http.Request, _ []*http.Request) error {
+				r.URL.Opaque = r.URL.Path
+				return nil
+			},
+			Transport: &http.Transport{
+				// if a proxy is set with the proper envvars, we will use it
+				// as long as the req does not match an entry in no_proxy env var
+				Proxy:                 http.ProxyFromEnvironment,
+				DialContext:           dialer.DialContext,
+				TLSClientConfig:       tlsConfig,
+				TLSHandshakeTimeout:   DefaultConnectTimeout,
+				ResponseHeaderTimeout: DefaultConnectTimeout,
+			},
+		},
+		Attempts: DefaultAttempts,
+		Backoff:  DefaultBackoff,
+	}
+}
+
+// permanentError marks an error that retrying cannot fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent wraps an error returned by the function passed to Fetch, so that the download is not retried.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// Fetch downloads url and passes the response body to fn. If the request, the response status or fn fail,
+// the whole download is retried unless the error is permanent, such as a client error status.
+// Local files, given as file:// URLs or as paths, are passed to fn directly.
+func (c *Client) Fetch(ctx context.Context, url string, fn func(body io.Reader) error) error {
+	return c.FetchContent(ctx, url, func(body io.Reader, _ string) error {
+		return fn(body)
+	})
+}
+
+// FetchContent is like Fetch, but also passes the Content-Type of the response to fn. It is empty for local files.
+func (c *Client) FetchContent(ctx context.Context, url string, fn func(body io.Reader, contentType string) error) error {
+	if path, ok := LocalPath(url); ok {
+		return readFile(path, func(body io.Reader) error {
+			return fn(body, "")
+		})
+	}
+
+	attempts := c.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	backoff := c.Backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = c.fetch(ctx, url, fn)
+		if err == nil {
+			return nil
+		}
+		var perm *permanentError
+		if errors.As(err, &perm) {
+			return err
+		}
+		if attempt >= attempts {
+			break
+		}
+
+		logrus.Warnf("Download of %s failed, retrying in %s (attempt %d of %d): %v", url, backoff, attempt, attempts, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+	return errors.Wrapf(err, "failed to download %s after %d attempts", url, attempts)
+}
+
+func (c *Client) fetch(ctx context.Context, url string, fn func(body io.Reader, contentType string) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("unexpected status %s", resp.Status)
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+			return Permanent(err)
+		}
+		return err
+	}
+	return fn(resp.Body, resp.Header.Get("Content-Type"))
+}
+
+// LocalPath returns the path of a file:// URL or of a URL without an http or https scheme.
+func LocalPath(rawURL string) (string, bool) {
+	lower := strings.ToLower(rawURL)
+	if strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") {
+		return "", false
+	}
+	if !strings.HasPrefix(lower, "file://") {
+		return filepath.FromSlash(rawURL), true
+	}
+
+	u, err := neturl.Parse(rawURL)
+	if err != nil {
+		return filepath.FromSlash(strings.TrimPrefix(rawURL[len("file://"):], "/")), true
+	}
+	p := u.Path
+	switch {
+	case u.Host == "" || strings.EqualFold(u.Host, "localhost"):
+		// file:///c:/artifacts has the path /c:/artifacts
+		if len(p) > 2 && p[0] == '/' && p[2] == ':' {
+			p = p[1:]
+		}
+	case strings.HasSuffix(u.Host, ":"):
+		// file://c:/artifacts has the drive as host
+		p = u.Host + p
+	default:
+		// file://server/share is a UNC path
+		p = "//" + u.Host + p
+	}
+	return filepath.FromSlash(p), true
+}
+
+func readFile(path string, fn func(body io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+	return fn(f)
+}
+
+// Stage calls write with a temporary file in the directory of dest, and renames it over dest
+// only if write succeeds. The temporary file is removed otherwise, so dest is never left truncated.
+func Stage(dest string, write func(f *os.File) error) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(dest), filepath.Base(dest)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			if rmErr := os.Remove(f.Name()); rmErr != nil && !os.IsNotExist(rmErr) {
+				logrus.Warnf("could not remove temporary file %s: %v", f.Name(), rmErr)
+			}
+		}
+	}()
+
+	if err = write(f); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), dest)
+}
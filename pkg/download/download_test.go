@@ -0,0 +1,216 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testClient() *Client {
+	c := NewClient(nil)
+	c.Attempts = 3
+	c.Backoff = time.Millisecond
+	return c
+}
+
+func TestFetch(t *testing.T) {
+	type test struct {
+		name        string
+		handler     func(attempt int32, w http.ResponseWriter)
+		fn          func(body io.Reader) error
+		attempts    int32
+		errExpected bool
+	}
+
+	read := func(body io.Reader) error {
+		_, err := io.ReadAll(body)
+		return err
+	}
+
+	tests := []test{
+		{
+			name:     "Success",
+			handler:  func(_ int32, w http.ResponseWriter) { _, _ = w.Write([]byte("ok")) },
+			fn:       read,
+			attempts: 1,
+		},
+		{
+			name: "Server errors are retried",
+			handler: func(attempt int32, w http.ResponseWriter) {
+				if attempt < 3 {
+					w.WriteHeader(http.StatusBadGateway)
+					return
+				}
+				_, _ = w.Write([]byte("ok"))
+			},
+			fn:       read,
+			attempts: 3,
+		},
+		{
+			name:        "Client errors are not retried",
+			handler:     func(_ int32, w http.ResponseWriter) { w.WriteHeader(http.StatusNotFound) },
+			fn:          read,
+			attempts:    1,
+			errExpected: true,
+		},
+		{
+			name:        "Gives up after all attempts",
+			handler:     func(_ int32, w http.ResponseWriter) { w.WriteHeader(http.StatusServiceUnavailable) },
+			fn:          read,
+			attempts:    3,
+			errExpected: true,
+		},
+		{
+			name:        "Permanent errors of the body handler are not retried",
+			handler:     func(_ int32, w http.ResponseWriter) { _, _ = w.Write([]byte("ok")) },
+			fn:          func(io.Reader) error { return Permanent(errors.New("checksum mismatch")) },
+			attempts:    1,
+			errExpected: true,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			var attempts int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				tst.handler(atomic.AddInt32(&attempts, 1), w)
+			}))
+			defer srv.Close()
+
+			err := testClient().Fetch(context.Background(), srv.URL, tst.fn)
+			if err != nil && !tst.errExpected {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if err == nil && tst.errExpected {
+				t.Error("expected an error")
+			}
+			if attempts != tst.attempts {
+				t.Errorf("expected %d attempts, got %d", tst.attempts, attempts)
+			}
+		})
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	c := testClient()
+	c.Attempts = 1
+	c.HTTP.Timeout = 50 * time.Millisecond
+	if err := c.Fetch(context.Background(), srv.URL, func(io.Reader) error { return nil }); err == nil {
+		t.Error("expected a timeout error")
+	}
+}
+
+func TestFetchLocal(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "csi-proxy-v1.1.1.tar.gz")
+	if err := os.WriteFile(file, []byte("archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, url := range []string{file, "file://" + filepath.ToSlash(file)} {
+		var content []byte
+		err := testClient().Fetch(context.Background(), url, func(body io.Reader) error {
+			var err error
+			content, err = io.ReadAll(body)
+			return err
+		})
+		if err != nil {
+			t.Errorf("unexpected error fetching %s: %v", url, err)
+		}
+		if string(content) != "archive" {
+			t.Errorf("expected the content of %s, got %q", file, content)
+		}
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	tests := map[string]string{
+		"file:///c:/artifacts/csi-proxy.tar.gz": "c:/artifacts/csi-proxy.tar.gz",
+		"file://c:/artifacts/csi-proxy.tar.gz":  "c:/artifacts/csi-proxy.tar.gz",
+		"file://server/share/csi-proxy.tar.gz":  "//server/share/csi-proxy.tar.gz",
+		"c:/artifacts/csi-proxy.tar.gz":         "c:/artifacts/csi-proxy.tar.gz",
+	}
+	for url, expected := range tests {
+		path, ok := LocalPath(url)
+		if !ok || path != filepath.FromSlash(expected) {
+			t.Errorf("expected %s to be the local path %s, got %s", url, expected, path)
+		}
+	}
+	if _, ok := LocalPath("HTTPS://example.com/csi-proxy.tar.gz"); ok {
+		t.Error("expected an https URL not to be local")
+	}
+}
+
+func TestStage(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "csi-proxy.exe")
+	if err := os.WriteFile(dest, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := Stage(dest, func(f *os.File) error {
+		_, _ = f.Write([]byte("trunc"))
+		return errors.New("connection reset")
+	})
+	if err == nil {
+		t.Fatal("expected the write error")
+	}
+	assertContent(t, dest, "old")
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("expected the temporary file to be removed, found %d files", len(entries))
+	}
+
+	if err := Stage(dest, func(f *os.File) error {
+		_, err := f.Write([]byte("new"))
+		return err
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertContent(t, dest, "new")
+}
+
+func assertContent(t *testing.T, path, expected string) {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != expected {
+		t.Errorf("expected %s to contain %q, got %q", path, expected, content)
+	}
+}
+
+func TestFetchContentType(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/zip")
+		_, _ = w.Write([]byte("archive"))
+	}))
+	defer srv.Close()
+
+	var contentType string
+	err := testClient().FetchContent(context.Background(), srv.URL, func(_ io.Reader, ct string) error {
+		contentType = ct
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if contentType != "application/zip" {
+		t.Errorf("expected the content type of the response, got %q", contentType)
+	}
+}
@@ -0,0 +1,70 @@
+package download
+
+import (
+	"context"
+	"crypto"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/wins/pkg/signatures"
+)
+
+// SignatureConfig requires a downloaded artifact to have a detached signature by one of the trusted keys.
+// Signatures are base64 encoded, ECDSA signatures of the SHA-256 of the artifact as created by cosign
+// sign-blob, or ed25519 signatures of the artifact itself.
+type SignatureConfig struct {
+	// URL of the signature, formatted for Go sprintf with the version like the URL of the artifact.
+	// Defaults to the URL of the artifact with a .sig suffix.
+	URL string `yaml:"url" json:"url,omitempty"`
+	// TrustedKeyFiles are PEM files containing ed25519 or ECDSA public keys.
+	TrustedKeyFiles []string `yaml:"trustedKeyFiles" json:"trustedKeyFiles"`
+}
+
+func (c *SignatureConfig) Validate() error {
+	if len(c.TrustedKeyFiles) == 0 {
+		return errors.New("at least one trusted key file must be provided")
+	}
+	return nil
+}
+
+// SignatureURL returns the URL of the signature of the artifact at artifactURL.
+func (c *SignatureConfig) SignatureURL(artifactURL string) string {
+	if c.URL != "" {
+		return c.URL
+	}
+	return artifactURL + signatures.Suffix
+}
+
+// Signature is a detached signature of an artifact and the keys that are trusted to have created it.
+type Signature struct {
+	keys      []crypto.PublicKey
+	signature []byte
+}
+
+// FetchSignature loads the trusted keys and downloads the signature from url.
+func (c *Client) FetchSignature(ctx context.Context, cfg *SignatureConfig, url string) (*Signature, error) {
+	keys, err := signatures.LoadPublicKeys(cfg.TrustedKeyFiles)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not load trusted keys")
+	}
+
+	var sig []byte
+	err = c.Fetch(ctx, url, func(body io.Reader) error {
+		var err error
+		sig, err = io.ReadAll(body)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not download signature")
+	}
+	return &Signature{keys: keys, signature: sig}, nil
+}
+
+// Verify checks the signature of the artifact.
+func (s *Signature) Verify(artifact io.Reader) error {
+	content, err := io.ReadAll(artifact)
+	if err != nil {
+		return err
+	}
+	return signatures.Verify(s.keys, content, s.signature)
+}
@@ -0,0 +1,65 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/wins/pkg/signatures"
+)
+
+func TestSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "release.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	artifact := []byte("csi-proxy archive")
+	sig, err := signatures.Sign(priv, artifact)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/csi-proxy.tar.gz.sig", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(sig)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	cfg := &SignatureConfig{TrustedKeyFiles: []string{keyFile}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+
+	s, err := testClient().FetchSignature(context.Background(), cfg, cfg.SignatureURL(srv.URL+"/csi-proxy.tar.gz"))
+	if err != nil {
+		t.Fatalf("unexpected error fetching the signature: %v", err)
+	}
+	if err := s.Verify(bytes.NewReader(artifact)); err != nil {
+		t.Errorf("unexpected verification error: %v", err)
+	}
+	if err := s.Verify(bytes.NewReader([]byte("tampered archive"))); err == nil {
+		t.Error("expected a verification error for a tampered artifact")
+	}
+
+	if _, err := testClient().FetchSignature(context.Background(), cfg, srv.URL+"/missing.sig"); err == nil {
+		t.Error("expected an error for a missing signature")
+	}
+}
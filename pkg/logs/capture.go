@@ -0,0 +1,85 @@
+package logs
+
+import (
+	"flag"
+	"io"
+	"path/filepath"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// CaptureCommand is the command of the wins binary that runs an executable and captures its output.
+const CaptureCommand = "svc-wrap"
+
+// CaptureConfig configures the capture of the stdout and stderr of a service into a rotated log file.
+type CaptureConfig struct {
+	// File is the log file, defaults to <name>.log in the log directory of wins.
+	File           string `yaml:"file" json:"file,omitempty"`
+	RotationConfig `yaml:",inline"`
+}
+
+func (c *CaptureConfig) Validate() error {
+	if c.File != "" && !filepath.IsAbs(c.File) {
+		return errors.Errorf("file %s must be an absolute path", c.File)
+	}
+	return c.RotationConfig.Validate()
+}
+
+// Args returns the arguments of CaptureCommand that run exe with args and capture its output as configured.
+func (c *CaptureConfig) Args(exe string, args ...string) []string {
+	wrapped := []string{CaptureCommand, "--log-file", c.File}
+	if c.MaxSize > 0 {
+		wrapped = append(wrapped, "--max-size", strconv.Itoa(c.MaxSize))
+	}
+	if c.MaxAge > 0 {
+		wrapped = append(wrapped, "--max-age", strconv.Itoa(c.MaxAge))
+	}
+	if c.MaxBackups > 0 {
+		wrapped = append(wrapped, "--max-backups", strconv.Itoa(c.MaxBackups))
+	}
+	if c.Compress {
+		wrapped = append(wrapped, "--compress")
+	}
+	wrapped = append(wrapped, "--", exe)
+	return append(wrapped, args...)
+}
+
+// ParseCaptureArgs parses the arguments that follow CaptureCommand, returning the capture configuration
+// and the command line of the executable.
+func ParseCaptureArgs(args []string) (*CaptureConfig, []string, error) {
+	c := &CaptureConfig{}
+	fs := flag.NewFlagSet(CaptureCommand, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&c.File, "log-file", "", "")
+	fs.IntVar(&c.MaxSize, "max-size", 0, "")
+	fs.IntVar(&c.MaxAge, "max-age", 0, "")
+	fs.IntVar(&c.MaxBackups, "max-backups", 0, "")
+	fs.BoolVar(&c.Compress, "compress", false, "")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if c.File == "" {
+		return nil, nil, errors.New("--log-file is required")
+	}
+	if err := c.Validate(); err != nil {
+		return nil, nil, err
+	}
+	if fs.NArg() == 0 {
+		return nil, nil, errors.New("the executable to run is missing")
+	}
+	return c, fs.Args(), nil
+}
+
+// UnwrapCommandLine returns the command line of the executable if cmd runs it through CaptureCommand,
+// otherwise cmd.
+func UnwrapCommandLine(cmd []string) []string {
+	if len(cmd) < 2 || cmd[1] != CaptureCommand {
+		return cmd
+	}
+	if _, wrapped, err := ParseCaptureArgs(cmd[2:]); err == nil {
+		return wrapped
+	}
+	return cmd
+}
@@ -0,0 +1,34 @@
+package logs
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCaptureArgs(t *testing.T) {
+	c := &CaptureConfig{
+		File:           filepath.Join(t.TempDir(), "exporter.log"),
+		RotationConfig: RotationConfig{MaxSize: 10, MaxBackups: 3, Compress: true},
+	}
+	args := c.Args(`c:\bin\exporter.exe`, "--port", "9182", "--", "-v")
+
+	parsed, cmd, err := ParseCaptureArgs(args[1:])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(parsed, c) {
+		t.Errorf("expected %+v, got %+v", c, parsed)
+	}
+	expected := []string{`c:\bin\exporter.exe`, "--port", "9182", "--", "-v"}
+	if !reflect.DeepEqual(cmd, expected) {
+		t.Errorf("expected command line %v, got %v", expected, cmd)
+	}
+	if unwrapped := UnwrapCommandLine(append([]string{`c:\wins\svc-wrap.exe`}, args...)); !reflect.DeepEqual(unwrapped, expected) {
+		t.Errorf("expected unwrapped command line %v, got %v", expected, unwrapped)
+	}
+
+	if _, _, err := ParseCaptureArgs([]string{"--log-file", "exporter.log", "--", "exporter.exe"}); err == nil {
+		t.Error("expected an error for a relative log file")
+	}
+}
@@ -0,0 +1,82 @@
+package logs
+
+import (
+	"io"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// FileConfig configures a rotated log file that logrus entries are written to.
+type FileConfig struct {
+	// Path is the log file, defaults to <name>.log in the log directory of wins.
+	Path           string `yaml:"path" json:"path,omitempty"`
+	RotationConfig `yaml:",inline"`
+}
+
+func (c *FileConfig) Validate() error {
+	if c.Path != "" && !filepath.IsAbs(c.Path) {
+		return errors.Errorf("path %s must be an absolute path", c.Path)
+	}
+	return c.RotationConfig.Validate()
+}
+
+// FileHook writes logrus entries of all levels to a rotated log file, independent of the output of logrus.
+type FileHook struct {
+	formatter logrus.Formatter
+
+	mu     sync.Mutex
+	file   io.WriteCloser
+	closed bool
+}
+
+// NewFileHook returns a hook that writes to the file configured by cfg, creating its directory if needed.
+func NewFileHook(cfg FileConfig) (*FileHook, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("the path of the log file is not set")
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &FileHook{
+		formatter: &logrus.TextFormatter{DisableColors: true, FullTimestamp: true},
+		file:      NewRotatingFile(cfg.Path, cfg.RotationConfig),
+	}, nil
+}
+
+func (h *FileHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *FileHook) Fire(e *logrus.Entry) error {
+	line, err := h.formatter.Format(e)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil
+	}
+	if _, err := h.file.Write(line); err == nil {
+		return nil
+	}
+	// the file is opened again by the next write after it was closed, which recovers from a handle that became
+	// invalid, e.g. because the file was removed or moved away by a tool rotating it
+	_ = h.file.Close()
+	_, err = h.file.Write(line)
+	return err
+}
+
+// Close closes the file, entries that are fired afterwards are dropped.
+func (h *FileHook) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	return h.file.Close()
+}
@@ -0,0 +1,74 @@
+package logs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestFileHook(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "rancher-wins.log")
+	hook, err := NewFileHook(FileConfig{Path: path})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	logger.AddHook(hook)
+	logger.Info("first")
+
+	if err := hook.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logger.Warn("second")
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s := string(content); !strings.Contains(s, "level=info msg=first") || strings.Contains(s, "second") {
+		t.Errorf("expected only the first entry in %s, got %q", path, s)
+	}
+
+	// a write that fails is retried once the file was closed, which opens it again
+	file := &staleFile{}
+	hook = &FileHook{formatter: &logrus.TextFormatter{DisableColors: true}, file: file}
+	logger.AddHook(hook)
+	logger.Error("third")
+	if !strings.Contains(file.String(), "msg=third") || file.closes != 1 {
+		t.Errorf("expected the entry to be written after reopening the file, got %q after %d closes", file.String(), file.closes)
+	}
+}
+
+// staleFile fails writes until it was closed, like a file whose handle became invalid.
+type staleFile struct {
+	strings.Builder
+	closes int
+}
+
+func (f *staleFile) Write(p []byte) (int, error) {
+	if f.closes == 0 {
+		return 0, errors.New("the handle is invalid")
+	}
+	return f.Builder.Write(p)
+}
+
+func (f *staleFile) Close() error {
+	f.closes++
+	return nil
+}
+
+func TestFileConfigValidate(t *testing.T) {
+	if err := (&FileConfig{Path: "logs/rancher-wins.log"}).Validate(); err == nil {
+		t.Error("expected a relative path to be rejected")
+	}
+	if err := (&FileConfig{RotationConfig: RotationConfig{MaxBackups: -1}}).Validate(); err == nil {
+		t.Error("expected a negative maxBackups to be rejected")
+	}
+}
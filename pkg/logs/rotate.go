@@ -0,0 +1,48 @@
+package logs
+
+import (
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// RotationConfig configures when a log file is rotated and how long the rotated files are kept.
+type RotationConfig struct {
+	// MaxSize is the size in megabytes after which the file is rotated, defaults to 100.
+	MaxSize int `yaml:"maxSize" json:"maxSize,omitempty"`
+	// MaxAge is the number of days rotated files are kept, they are not removed because of their age if unset.
+	MaxAge int `yaml:"maxAge" json:"maxAge,omitempty"`
+	// MaxBackups is the number of rotated files that are kept, all are kept if unset.
+	MaxBackups int `yaml:"maxBackups" json:"maxBackups,omitempty"`
+	// Compress rotated files with gzip.
+	Compress bool `yaml:"compress" json:"compress,omitempty"`
+}
+
+func (c *RotationConfig) Validate() error {
+	if c.MaxSize < 0 || c.MaxAge < 0 || c.MaxBackups < 0 {
+		return errors.New("maxSize, maxAge and maxBackups cannot be negative")
+	}
+	return nil
+}
+
+// NewRotatingFile returns a writer that appends to the file at path and rotates it according to cfg. The
+// rotated files are named after the file with the time of the rotation, e.g. csi-proxy-2024-01-02T15-04-05.000.log.
+// Writes are safe for concurrent use.
+func NewRotatingFile(path string, cfg RotationConfig) io.WriteCloser {
+	return &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    cfg.MaxSize,
+		MaxAge:     cfg.MaxAge,
+		MaxBackups: cfg.MaxBackups,
+		Compress:   cfg.Compress,
+	}
+}
+
+// RotatedFiles returns the rotated files of the log file at path.
+func RotatedFiles(path string) ([]string, error) {
+	ext := filepath.Ext(path)
+	return filepath.Glob(strings.TrimSuffix(path, ext) + "-*" + ext + "*")
+}
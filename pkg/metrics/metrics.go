@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+// Namespace prefixes the names of all wins metrics.
+const Namespace = "wins"
+
+// Config enables the Prometheus metrics endpoint of wins.
+type Config struct {
+	// Address is the host and port the endpoint listens on, e.g. 127.0.0.1:9796.
+	Address string `yaml:"address" json:"address"`
+}
+
+func (c *Config) Validate() error {
+	if _, _, err := net.SplitHostPort(c.Address); err != nil {
+		return errors.Wrapf(err, "invalid metrics address %s", c.Address)
+	}
+	return nil
+}
+
+// Serve exposes the metrics registered with the default Prometheus registry at /metrics until ctx is done.
+func Serve(ctx context.Context, cfg *Config) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{
+		Addr:              cfg.Address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	logrus.Infof("Serving metrics on %s/metrics", cfg.Address)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logrus.Errorf("Metrics endpoint stopped: %v", err)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package paths
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// RestrictDirectory creates the directory if needed and makes it accessible to its owner only.
+func RestrictDirectory(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.Wrapf(err, "could not create %s", dir)
+	}
+	return os.Chmod(dir, 0700)
+}
@@ -0,0 +1,60 @@
+package paths
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/wins/pkg/defaults"
+	"golang.org/x/sys/windows"
+)
+
+// permissionBuiltinAdministratorsAndLocalSystemInherited is inherited by everything created in the directory.
+const permissionBuiltinAdministratorsAndLocalSystemInherited = "D:P(A;OICI;GA;;;BA)(A;OICI;GA;;;SY)"
+
+// RestrictDirectory creates the directory if needed, and makes it and everything in it owned by the built-in
+// Administrators and accessible to them and LocalSystem only. Links in the directory are removed, as they could
+// point to files that other users can write.
+func RestrictDirectory(dir string) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return errors.Wrapf(err, "could not create %s", dir)
+	}
+	if fi, err := os.Lstat(dir); err != nil {
+		return err
+	} else if !fi.IsDir() {
+		return errors.Errorf("%s is not a directory", dir)
+	}
+
+	owner, err := windows.CreateWellKnownSid(windows.WinBuiltinAdministratorsSid)
+	if err != nil {
+		return errors.Wrap(err, "could not get the SID of the built-in Administrators")
+	}
+
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return errors.Wrapf(os.Remove(path), "could not remove link %s", path)
+		}
+
+		permission := defaults.PermissionBuiltinAdministratorsAndLocalSystem
+		if d.IsDir() {
+			permission = permissionBuiltinAdministratorsAndLocalSystemInherited
+		}
+		sd, err := windows.SecurityDescriptorFromString(permission)
+		if err != nil {
+			return errors.Wrapf(err, "could not get security descriptor for %s", path)
+		}
+		dacl, _, err := sd.DACL()
+		if err != nil {
+			return errors.Wrapf(err, "could not get DACL for %s", path)
+		}
+
+		err = windows.SetNamedSecurityInfo(path, windows.SE_FILE_OBJECT,
+			windows.OWNER_SECURITY_INFORMATION|windows.DACL_SECURITY_INFORMATION|windows.PROTECTED_DACL_SECURITY_INFORMATION,
+			owner, nil, dacl, nil)
+		return errors.Wrapf(err, "could not restrict permissions of %s", path)
+	})
+}
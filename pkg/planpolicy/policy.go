@@ -0,0 +1,234 @@
+package planpolicy
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/system-agent/pkg/applyinator"
+)
+
+// Policy restricts the content of system-agent plans. Every rule is optional, an empty Policy allows any plan.
+type Policy struct {
+	// AllowedImages are fully qualified registries, namespaces or repositories, e.g. docker.io/rancher.
+	// An instruction image is allowed if its repository equals or is nested under one of them.
+	AllowedImages []string `yaml:"allowedImages" json:"allowedImages,omitempty"`
+	// AllowedCommands are the only commands instructions may run. Plans may not write files named like them, or
+	// files whose path matches one of their argument patterns, e.g. a script they are allowed to run.
+	AllowedCommands []AllowedCommand `yaml:"allowedCommands" json:"allowedCommands,omitempty"`
+	// ForbiddenEnvVars are environment variable names instructions may not set, compared case-insensitively.
+	ForbiddenEnvVars []string `yaml:"forbiddenEnvVars" json:"forbiddenEnvVars,omitempty"`
+	// MaxInstructions limits the number of one time and periodic instructions in a plan, 0 means no limit.
+	MaxInstructions int `yaml:"maxInstructions" json:"maxInstructions,omitempty"`
+
+	args []commandArgs
+	// filePaths are the argument patterns of the allowed commands, matching paths case-insensitively
+	filePaths []commandArgs
+}
+
+// AllowedCommand is a command an instruction may run.
+type AllowedCommand struct {
+	// Command is either a full path or a file name, compared case-insensitively and without a .exe extension.
+	Command string `yaml:"command" json:"command"`
+	// Args are regular expressions, every argument must fully match one of them. Any arguments are allowed if empty.
+	Args []string `yaml:"args" json:"args,omitempty"`
+}
+
+type commandArgs []*regexp.Regexp
+
+// Violation is a rule of the Policy that a plan breaks.
+type Violation struct {
+	// Instruction is the name of the offending instruction, empty for violations of the whole plan.
+	Instruction string
+	Message     string
+}
+
+func (v Violation) String() string {
+	if v.Instruction == "" {
+		return v.Message
+	}
+	return fmt.Sprintf("instruction %s: %s", v.Instruction, v.Message)
+}
+
+// Validate compiles the argument patterns of the Policy, it must be called before Evaluate.
+func (p *Policy) Validate() error {
+	if p.MaxInstructions < 0 {
+		return errors.New("maxInstructions cannot be negative")
+	}
+
+	p.args = make([]commandArgs, len(p.AllowedCommands))
+	p.filePaths = make([]commandArgs, len(p.AllowedCommands))
+	for i, c := range p.AllowedCommands {
+		if strings.TrimSpace(c.Command) == "" {
+			return fmt.Errorf("allowed command %d cannot be empty", i)
+		}
+		for _, a := range c.Args {
+			re, err := regexp.Compile("^(?:" + a + ")$")
+			if err != nil {
+				return errors.Wrapf(err, "invalid argument pattern %q of command %s", a, c.Command)
+			}
+			p.args[i] = append(p.args[i], re)
+			p.filePaths[i] = append(p.filePaths[i], regexp.MustCompile("(?i)"+re.String()))
+		}
+	}
+
+	for _, img := range p.AllowedImages {
+		if strings.TrimSpace(img) == "" {
+			return errors.New("allowed images cannot contain an empty entry")
+		}
+	}
+	return nil
+}
+
+// Evaluate returns every violation of the Policy in the plan.
+func (p *Policy) Evaluate(plan applyinator.Plan) []Violation {
+	var violations []Violation
+
+	count := len(plan.OneTimeInstructions) + len(plan.PeriodicInstructions)
+	if p.MaxInstructions > 0 && count > p.MaxInstructions {
+		violations = append(violations, Violation{
+			Message: fmt.Sprintf("plan has %d instructions, at most %d are allowed", count, p.MaxInstructions),
+		})
+	}
+
+	// a plan could otherwise write its own executable named like an allowed command, or a script that an allowed
+	// command may be run with, and run it
+	for _, f := range plan.Files {
+		if f.Directory {
+			continue
+		}
+		for i, allowed := range p.AllowedCommands {
+			if sameCommand(allowed.Command, f.Path) {
+				violations = append(violations, Violation{
+					Message: fmt.Sprintf("file %s would replace allowed command %s", f.Path, allowed.Command),
+				})
+				break
+			}
+			if p.argumentPath(i, f.Path) {
+				violations = append(violations, Violation{
+					Message: fmt.Sprintf("file %s could be passed as an argument to allowed command %s", f.Path, allowed.Command),
+				})
+				break
+			}
+		}
+	}
+
+	for _, i := range plan.OneTimeInstructions {
+		violations = append(violations, p.evaluateInstruction(i.CommonInstruction)...)
+	}
+	for _, i := range plan.PeriodicInstructions {
+		violations = append(violations, p.evaluateInstruction(i.CommonInstruction)...)
+	}
+	return violations
+}
+
+func (p *Policy) evaluateInstruction(i applyinator.CommonInstruction) []Violation {
+	var violations []Violation
+	add := func(format string, args ...interface{}) {
+		violations = append(violations, Violation{Instruction: i.Name, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if i.Image != "" && len(p.AllowedImages) > 0 && !p.imageAllowed(i.Image) {
+		add("image %s is not allowed", i.Image)
+	}
+
+	if i.Command != "" && len(p.AllowedCommands) > 0 {
+		if ok, reason := p.commandAllowed(i.Command, i.Args); !ok {
+			add("%s", reason)
+		}
+	}
+
+	for _, env := range i.Env {
+		name := strings.SplitN(env, "=", 2)[0]
+		for _, forbidden := range p.ForbiddenEnvVars {
+			if strings.EqualFold(name, forbidden) {
+				add("environment variable %s is forbidden", name)
+			}
+		}
+	}
+	return violations
+}
+
+func (p *Policy) imageAllowed(image string) bool {
+	repo := repository(image)
+	for _, allowed := range p.AllowedImages {
+		allowed = strings.TrimSuffix(strings.ToLower(allowed), "/")
+		if repo == allowed || strings.HasPrefix(repo, allowed+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) commandAllowed(command string, args []string) (bool, string) {
+	for i, allowed := range p.AllowedCommands {
+		if !sameCommand(allowed.Command, command) {
+			continue
+		}
+		if len(p.args[i]) == 0 {
+			return true, ""
+		}
+		for _, arg := range args {
+			if !matchesAny(p.args[i], arg) {
+				return false, fmt.Sprintf("argument %q of command %s is not allowed", arg, command)
+			}
+		}
+		return true, ""
+	}
+	return false, fmt.Sprintf("command %s is not allowed", command)
+}
+
+// repository returns the fully qualified repository of an image reference, without tag or digest.
+// References without a registry are expanded the same way as the container runtime does, e.g.
+// rancher/wins becomes docker.io/rancher/wins and busybox becomes docker.io/library/busybox.
+func repository(image string) string {
+	image = strings.ToLower(image)
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 1 {
+		return "docker.io/library/" + image
+	}
+	if !strings.ContainsAny(parts[0], ".:") && parts[0] != "localhost" {
+		return "docker.io/" + image
+	}
+	return image
+}
+
+func sameCommand(allowed, command string) bool {
+	normalize := func(s string) string {
+		return strings.TrimSuffix(strings.ToLower(strings.ReplaceAll(s, `\`, "/")), ".exe")
+	}
+	allowed, command = normalize(allowed), normalize(command)
+	if strings.Contains(allowed, "/") {
+		return allowed == command
+	}
+	return allowed == path.Base(command)
+}
+
+// argumentPath reports whether the file path matches an argument pattern of the allowed command, in any case and
+// with either path separator, as Windows resolves all of them to the same file.
+func (p *Policy) argumentPath(command int, file string) bool {
+	for _, variant := range []string{file, strings.ReplaceAll(file, "/", `\`), strings.ReplaceAll(file, `\`, "/")} {
+		if matchesAny(p.filePaths[command], variant) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
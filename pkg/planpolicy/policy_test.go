@@ -0,0 +1,157 @@
+package planpolicy
+
+import (
+	"testing"
+
+	"github.com/rancher/system-agent/pkg/applyinator"
+)
+
+func instruction(name, image, command string, args, env []string) applyinator.OneTimeInstruction {
+	return applyinator.OneTimeInstruction{
+		CommonInstruction: applyinator.CommonInstruction{
+			Name:    name,
+			Image:   image,
+			Command: command,
+			Args:    args,
+			Env:     env,
+		},
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	policy := &Policy{
+		AllowedImages: []string{"docker.io/rancher", "registry.example.com/"},
+		AllowedCommands: []AllowedCommand{
+			{Command: "powershell.exe", Args: []string{"-File", `c:\\scripts\\[a-z-]+\.ps1`}},
+			{Command: `c:\usr\local\bin\rke2.exe`},
+		},
+		ForbiddenEnvVars: []string{"HTTP_PROXY"},
+		MaxInstructions:  2,
+	}
+	if err := policy.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+
+	type test struct {
+		name       string
+		plan       applyinator.Plan
+		violations int
+	}
+
+	tests := []test{
+		{
+			name: "Compliant plan",
+			plan: applyinator.Plan{OneTimeInstructions: []applyinator.OneTimeInstruction{
+				instruction("install", "rancher/system-agent-installer-rke2:v1.30.1-rke2r1", "", nil, nil),
+				instruction("configure", "", "PowerShell", []string{"-File", `c:\scripts\configure-node.ps1`}, []string{"LOG_LEVEL=debug"}),
+			}},
+		},
+		{
+			name: "Image from another registry",
+			plan: applyinator.Plan{OneTimeInstructions: []applyinator.OneTimeInstruction{
+				instruction("install", "evil.example.com/rancher/installer:latest", "", nil, nil),
+			}},
+			violations: 1,
+		},
+		{
+			name: "Image without registry outside the allowed namespace",
+			plan: applyinator.Plan{OneTimeInstructions: []applyinator.OneTimeInstruction{
+				instruction("install", "busybox@sha256:0123", "", nil, nil),
+			}},
+			violations: 1,
+		},
+		{
+			name: "Command and argument checks",
+			plan: applyinator.Plan{OneTimeInstructions: []applyinator.OneTimeInstruction{
+				instruction("shell", "", "cmd.exe", []string{"/c", "whoami"}, nil),
+				instruction("script", "", "powershell", []string{"-Command", "Remove-Item c:\\"}, nil),
+			}},
+			violations: 2,
+		},
+		{
+			name: "Full path command must match exactly",
+			plan: applyinator.Plan{OneTimeInstructions: []applyinator.OneTimeInstruction{
+				instruction("rke2", "", `C:/usr/local/bin/rke2`, []string{"server"}, nil),
+				instruction("fake-rke2", "", `c:\temp\rke2.exe`, nil, nil),
+			}},
+			violations: 1,
+		},
+		{
+			name: "File replacing an allowed command",
+			plan: applyinator.Plan{
+				Files: []applyinator.File{
+					{Path: `C:\tmp\powershell.exe`, Content: "TVo="},
+					{Path: `c:/usr/local/bin/rke2.exe`, Content: "TVo="},
+					{Path: `c:\tmp\powershell`, Directory: true},
+				},
+				OneTimeInstructions: []applyinator.OneTimeInstruction{
+					instruction("script", "", `C:\tmp\powershell.exe`, []string{"-File", `c:\scripts\configure-node.ps1`}, nil),
+				},
+			},
+			violations: 2,
+		},
+		{
+			name: "File writing a script an allowed command runs",
+			plan: applyinator.Plan{
+				Files: []applyinator.File{
+					{Path: `c:\scripts\x.ps1`, Content: "V3JpdGUtSG9zdA=="},
+					{Path: `C:/Scripts/configure-node.ps1`, Content: "V3JpdGUtSG9zdA=="},
+					{Path: `c:\data\node.ps1`, Content: "V3JpdGUtSG9zdA=="},
+				},
+				OneTimeInstructions: []applyinator.OneTimeInstruction{
+					instruction("script", "", "powershell", []string{"-File", `c:\scripts\x.ps1`}, nil),
+				},
+			},
+			violations: 2,
+		},
+		{
+			name: "Forbidden environment variable and too many instructions",
+			plan: applyinator.Plan{
+				OneTimeInstructions: []applyinator.OneTimeInstruction{
+					instruction("a", "", "", nil, []string{"http_proxy=http://proxy"}),
+					instruction("b", "", "", nil, nil),
+				},
+				PeriodicInstructions: []applyinator.PeriodicInstruction{{}},
+			},
+			violations: 2,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			violations := policy.Evaluate(tst.plan)
+			if len(violations) != tst.violations {
+				t.Errorf("expected %d violations, got %d: %v", tst.violations, len(violations), violations)
+			}
+		})
+	}
+}
+
+func TestRepository(t *testing.T) {
+	tests := map[string]string{
+		"busybox":                                  "docker.io/library/busybox",
+		"rancher/wins:v0.5.0":                      "docker.io/rancher/wins",
+		"registry.example.com:5000/team/app:1.0":   "registry.example.com:5000/team/app",
+		"localhost/app@sha256:abcdef":              "localhost/app",
+		"Registry.Example.com/Team/App@sha256:abc": "registry.example.com/team/app",
+	}
+	for image, expected := range tests {
+		if repo := repository(image); repo != expected {
+			t.Errorf("expected repository %s for %s, got %s", expected, image, repo)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	invalid := []*Policy{
+		{MaxInstructions: -1},
+		{AllowedCommands: []AllowedCommand{{Command: ""}}},
+		{AllowedCommands: []AllowedCommand{{Command: "cmd", Args: []string{"("}}}},
+		{AllowedImages: []string{" "}},
+	}
+	for _, p := range invalid {
+		if err := p.Validate(); err == nil {
+			t.Errorf("expected an error validating %+v", p)
+		}
+	}
+}
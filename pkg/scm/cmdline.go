@@ -0,0 +1,110 @@
+package scm
+
+import "strings"
+
+// JoinCommandLine builds the command line of a service from its executable and arguments, quoting them the way
+// the service manager does.
+func JoinCommandLine(exePath string, args ...string) string {
+	s := escapeArg(exePath)
+	for _, arg := range args {
+		s += " " + escapeArg(arg)
+	}
+	return s
+}
+
+// escapeArg quotes an argument exactly like syscall.EscapeArg on Windows, which the service manager of the host
+// uses, so that the command line of a service can be compared with the one JoinCommandLine builds. Arguments are
+// only quoted if they contain a space or tab, and quotes and the backslashes before them are always escaped.
+func escapeArg(s string) string {
+	if s == "" {
+		return `""`
+	}
+	needsBackslash := strings.ContainsAny(s, `"\`)
+	hasSpace := strings.ContainsAny(s, " \t")
+	if !needsBackslash && !hasSpace {
+		return s
+	}
+	if !needsBackslash {
+		return `"` + s + `"`
+	}
+
+	var b strings.Builder
+	if hasSpace {
+		b.WriteByte('"')
+	}
+	slashes := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			slashes++
+		case '"':
+			// backslashes before a quote are escaped, as well as the quote itself
+			b.WriteString(strings.Repeat(`\`, slashes+1))
+			slashes = 0
+		default:
+			slashes = 0
+		}
+		b.WriteByte(s[i])
+	}
+	if hasSpace {
+		// backslashes before the closing quote are escaped
+		b.WriteString(strings.Repeat(`\`, slashes))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+// SplitCommandLine splits the command line of a service into the executable and its arguments, following the
+// rules of CommandLineToArgvW.
+func SplitCommandLine(cmd string) []string {
+	var args []string
+	var arg strings.Builder
+	inArg, quoted := false, false
+	first := true
+
+	for i := 0; i < len(cmd); i++ {
+		c := cmd[i]
+		switch {
+		case (c == ' ' || c == '\t') && !quoted:
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg, first = false, false
+			}
+		case c == '"':
+			inArg = true
+			if quoted && !first && i+1 < len(cmd) && cmd[i+1] == '"' {
+				// a doubled quote inside quotes is a literal quote
+				arg.WriteByte('"')
+				i++
+				continue
+			}
+			quoted = !quoted
+		case c == '\\' && !first:
+			inArg = true
+			slashes := 0
+			for i < len(cmd) && cmd[i] == '\\' {
+				slashes++
+				i++
+			}
+			if i < len(cmd) && cmd[i] == '"' {
+				arg.WriteString(strings.Repeat(`\`, slashes/2))
+				if slashes%2 == 1 {
+					arg.WriteByte('"')
+				} else {
+					quoted = !quoted
+				}
+			} else {
+				arg.WriteString(strings.Repeat(`\`, slashes))
+				i--
+			}
+		default:
+			inArg = true
+			arg.WriteByte(c)
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args
+}
@@ -0,0 +1,41 @@
+package scm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCommandLine(t *testing.T) {
+	testCases := []struct {
+		args    []string
+		cmdLine string
+	}{
+		{
+			args:    []string{`c:\etc\rancher\wins\csi-proxy.exe`, "-windows-service", "-v=2"},
+			cmdLine: `c:\etc\rancher\wins\csi-proxy.exe -windows-service -v=2`,
+		},
+		{
+			args:    []string{`c:\Program Files\exporter\exporter.exe`, "--log.file", `c:\var\log\exporter.log`},
+			cmdLine: `"c:\Program Files\exporter\exporter.exe" --log.file c:\var\log\exporter.log`,
+		},
+		{
+			args:    []string{`c:\bin\agent.exe`, "", `say "hi"`, `c:\path with\space\`},
+			cmdLine: `c:\bin\agent.exe "" "say \"hi\"" "c:\path with\space\\"`,
+		},
+		{
+			// arguments without a space or tab are not quoted, even if they contain a quote or backslash
+			args:    []string{`c:\bin\agent.exe`, `a"b`, `C:\dir\`, `a\\"b`},
+			cmdLine: `c:\bin\agent.exe a\"b C:\dir\ a\\\\\"b`,
+		},
+	}
+
+	for _, tc := range testCases {
+		cmdLine := JoinCommandLine(tc.args[0], tc.args[1:]...)
+		if cmdLine != tc.cmdLine {
+			t.Errorf("expected command line %s, got %s", tc.cmdLine, cmdLine)
+		}
+		if args := SplitCommandLine(cmdLine); !reflect.DeepEqual(args, tc.args) {
+			t.Errorf("expected args %q, got %q", tc.args, args)
+		}
+	}
+}
@@ -0,0 +1,489 @@
+package scm
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Fake is an in-memory Manager for tests. Started services pass through StartPending and stopped
+// services through StopPending for PendingQueries queries before they settle. Dependencies are started
+// before the services that depend on them, and services cannot be stopped while services that depend
+// on them are running. Registry keys left behind by deleted services and event log sources are added with
+// AddServiceKey and AddEventSource. Failures of individual operations are injected with Fail.
+type Fake struct {
+	// PendingQueries is the number of queries a pending state is reported for.
+	PendingQueries int
+
+	mu           sync.Mutex
+	services     map[string]*fakeService
+	failures     map[string]error
+	keys         map[string]bool
+	eventSources map[string]bool
+}
+
+// FakeService is the state of a service of the Fake.
+type FakeService struct {
+	Config          Config
+	Status          Status
+	RecoveryActions []RecoveryAction
+	ResetPeriod     uint32
+	Environment     []string
+	Values          map[string]string
+	// MarkedForDelete services are removed once they have stopped.
+	MarkedForDelete bool
+	// FailToStart services stop with an exit code instead of reaching Running.
+	FailToStart bool
+	// Starts counts how often the service was started.
+	Starts int
+}
+
+type fakeService struct {
+	FakeService
+	pending int
+	target  State
+}
+
+// NewFake creates an empty Fake.
+func NewFake() *Fake {
+	return &Fake{
+		services:     map[string]*fakeService{},
+		failures:     map[string]error{},
+		keys:         map[string]bool{},
+		eventSources: map[string]bool{},
+	}
+}
+
+// Add creates a service in the given state, bypassing CreateService.
+func (f *Fake) Add(name string, cfg Config, state State) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.services[name] = &fakeService{FakeService: FakeService{
+		Config: cfg,
+		Status: Status{State: state},
+		Values: map[string]string{},
+	}}
+}
+
+// Service returns a copy of the state of the named service, and whether it exists.
+func (f *Fake) Service(name string) (FakeService, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, ok := f.services[name]
+	if !ok {
+		return FakeService{}, false
+	}
+	return s.FakeService, true
+}
+
+// AddServiceKey adds a registry key that a deleted service left behind.
+func (f *Fake) AddServiceKey(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.keys[name] = true
+}
+
+// ServiceKey reports whether a deleted service left its registry key behind.
+func (f *Fake) ServiceKey(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.keys[name]
+}
+
+// AddEventSource registers an event log source.
+func (f *Fake) AddEventSource(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.eventSources[name] = true
+}
+
+// EventSource reports whether the event log source is registered.
+func (f *Fake) EventSource(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.eventSources[name]
+}
+
+// SetFailToStart makes the named service stop with an exit code when it is started.
+func (f *Fake) SetFailToStart(name string, fail bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if s, ok := f.services[name]; ok {
+		s.FailToStart = fail
+	}
+}
+
+// Fail makes the operation of the named service return err until it is cleared with a nil err. Operations
+// are named after the methods of Manager and Service, e.g. CreateService, Start or UpdateConfig.
+func (f *Fake) Fail(name, op string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := name + "/" + op
+	if err == nil {
+		delete(f.failures, key)
+		return
+	}
+	f.failures[key] = err
+}
+
+func (f *Fake) failure(name, op string) error {
+	return f.failures[name+"/"+op]
+}
+
+func (f *Fake) CreateService(name, exePath string, cfg Config, args ...string) (Service, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure(name, "CreateService"); err != nil {
+		return nil, err
+	}
+	if s, ok := f.services[name]; ok {
+		if s.MarkedForDelete {
+			return nil, fmt.Errorf("%s: %w", name, ErrMarkedForDelete)
+		}
+		return nil, fmt.Errorf("%s: %w", name, ErrExists)
+	}
+	if cfg.StartType == 0 {
+		cfg.StartType = StartManual
+	}
+	if cfg.ServiceType == 0 {
+		cfg.ServiceType = ServiceWin32OwnProcess
+	}
+	if cfg.DisplayName == "" {
+		cfg.DisplayName = name
+	}
+	cfg.BinaryPathName = JoinCommandLine(exePath, args...)
+	f.services[name] = &fakeService{FakeService: FakeService{
+		Config: cfg,
+		Status: Status{State: Stopped},
+		Values: map[string]string{},
+	}}
+	return &fakeHandle{fake: f, name: name}, nil
+}
+
+func (f *Fake) OpenService(name string) (Service, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure(name, "OpenService"); err != nil {
+		return nil, err
+	}
+	s, ok := f.services[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, ErrNotExist)
+	}
+	if s.MarkedForDelete {
+		return nil, fmt.Errorf("%s: %w", name, ErrMarkedForDelete)
+	}
+	return &fakeHandle{fake: f, name: name}, nil
+}
+
+// RemoveServiceKey fails for services that still exist, as the key of a service is only left behind once the
+// service was deleted.
+func (f *Fake) RemoveServiceKey(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure(name, "RemoveServiceKey"); err != nil {
+		return err
+	}
+	if _, ok := f.services[name]; ok {
+		return fmt.Errorf("registry key of service %s is in use by the service", name)
+	}
+	if !f.keys[name] {
+		return fmt.Errorf("registry key of service %s: %w", name, ErrNotExist)
+	}
+	delete(f.keys, name)
+	return nil
+}
+
+func (f *Fake) RemoveEventSource(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure(name, "RemoveEventSource"); err != nil {
+		return err
+	}
+	if !f.eventSources[name] {
+		return fmt.Errorf("event log source %s: %w", name, ErrNotExist)
+	}
+	delete(f.eventSources, name)
+	return nil
+}
+
+// settle advances a pending state of the service, removing it if it stopped after being deleted.
+func (f *Fake) settle(name string, s *fakeService) {
+	if s.Status.State != StartPending && s.Status.State != StopPending {
+		return
+	}
+	if s.pending > 1 {
+		s.pending--
+		s.Status.CheckPoint++
+		return
+	}
+	s.pending = 0
+	s.Status.State = s.target
+	s.Status.CheckPoint = 0
+	if s.Status.State == Stopped && s.MarkedForDelete {
+		delete(f.services, name)
+	}
+}
+
+// start starts the service after its dependencies, which is done by the service manager on Windows.
+func (f *Fake) start(name string, visited map[string]bool) error {
+	s, ok := f.services[name]
+	if !ok {
+		return fmt.Errorf("%s: %w", name, ErrNotExist)
+	}
+	if visited[name] {
+		return fmt.Errorf("circular dependency on %s: %w", name, ErrDependencyFailed)
+	}
+	visited[name] = true
+
+	switch s.Status.State {
+	case Stopped:
+	case Running, StartPending:
+		return fmt.Errorf("%s: %w", name, ErrAlreadyRunning)
+	default:
+		return fmt.Errorf("service %s cannot be started in state %s", name, s.Status.State)
+	}
+	if s.Config.StartType == StartDisabled {
+		return fmt.Errorf("service %s is disabled", name)
+	}
+	for _, dep := range s.Config.Dependencies {
+		if err := f.start(dep, visited); err != nil && !isAlreadyRunning(err) {
+			return fmt.Errorf("dependency %s of %s: %w: %w", dep, name, ErrDependencyFailed, err)
+		}
+	}
+
+	s.Starts++
+	s.Status = Status{State: StartPending, WaitHint: s.Status.WaitHint}
+	s.pending = f.PendingQueries
+	s.target = Running
+	if s.FailToStart {
+		s.target = Stopped
+		s.Status.Win32ExitCode = 1
+	}
+	if s.pending == 0 {
+		f.settle(name, s)
+	}
+	return nil
+}
+
+func isAlreadyRunning(err error) bool {
+	return errors.Is(err, ErrAlreadyRunning)
+}
+
+// stop stops the service, failing if running services depend on it.
+func (f *Fake) stop(name string, s *fakeService) (Status, error) {
+	if s.Status.State == Stopped || s.Status.State == StopPending {
+		return s.Status, fmt.Errorf("%s: %w", name, ErrNotActive)
+	}
+	for depName, dep := range f.services {
+		if dep.Status.State == Stopped {
+			continue
+		}
+		for _, d := range dep.Config.Dependencies {
+			if d == name {
+				return s.Status, fmt.Errorf("%s depends on %s: %w", depName, name, ErrDependentServicesRunning)
+			}
+		}
+	}
+
+	s.Status.State = StopPending
+	s.pending = f.PendingQueries
+	s.target = Stopped
+	status := s.Status
+	if s.pending == 0 {
+		f.settle(name, s)
+	}
+	return status, nil
+}
+
+// dependents returns the active services that depend on name, the services that depend on them first.
+func (f *Fake) dependents(name string, visited map[string]bool) []string {
+	var names []string
+	for depName, dep := range f.services {
+		if visited[depName] || dep.Status.State == Stopped || !slices.Contains(dep.Config.Dependencies, name) {
+			continue
+		}
+		visited[depName] = true
+		names = append(names, f.dependents(depName, visited)...)
+		names = append(names, depName)
+	}
+	return names
+}
+
+// fakeHandle is an open service of the Fake.
+type fakeHandle struct {
+	fake *Fake
+	name string
+}
+
+// do runs fn with the service under the lock, unless a failure is injected for the operation.
+func (h *fakeHandle) do(op string, fn func(s *fakeService) error) error {
+	h.fake.mu.Lock()
+	defer h.fake.mu.Unlock()
+
+	if err := h.fake.failure(h.name, op); err != nil {
+		return err
+	}
+	s, ok := h.fake.services[h.name]
+	if !ok {
+		return fmt.Errorf("%s: %w", h.name, ErrNotExist)
+	}
+	return fn(s)
+}
+
+func (h *fakeHandle) Name() string {
+	return h.name
+}
+
+func (h *fakeHandle) Query() (Status, error) {
+	var status Status
+	err := h.do("Query", func(s *fakeService) error {
+		status = s.Status
+		h.fake.settle(h.name, s)
+		return nil
+	})
+	return status, err
+}
+
+func (h *fakeHandle) Start(...string) error {
+	return h.do("Start", func(s *fakeService) error {
+		return h.fake.start(h.name, map[string]bool{})
+	})
+}
+
+func (h *fakeHandle) Control(cmd Cmd) (Status, error) {
+	var status Status
+	err := h.do("Control", func(s *fakeService) error {
+		switch cmd {
+		case Stop:
+			var err error
+			status, err = h.fake.stop(h.name, s)
+			return err
+		case Interrogate:
+			status = s.Status
+			return nil
+		default:
+			return fmt.Errorf("unsupported control %d", cmd)
+		}
+	})
+	return status, err
+}
+
+func (h *fakeHandle) Config() (Config, error) {
+	var cfg Config
+	err := h.do("Config", func(s *fakeService) error {
+		cfg = s.Config
+		cfg.Dependencies = append([]string(nil), s.Config.Dependencies...)
+		return nil
+	})
+	return cfg, err
+}
+
+func (h *fakeHandle) UpdateConfig(cfg Config) error {
+	return h.do("UpdateConfig", func(s *fakeService) error {
+		cfg.Dependencies = append([]string(nil), cfg.Dependencies...)
+		s.Config = cfg
+		return nil
+	})
+}
+
+func (h *fakeHandle) RecoveryActions() ([]RecoveryAction, error) {
+	var actions []RecoveryAction
+	err := h.do("RecoveryActions", func(s *fakeService) error {
+		actions = append(actions, s.RecoveryActions...)
+		return nil
+	})
+	return actions, err
+}
+
+func (h *fakeHandle) ResetPeriod() (uint32, error) {
+	var period uint32
+	err := h.do("ResetPeriod", func(s *fakeService) error {
+		period = s.ResetPeriod
+		return nil
+	})
+	return period, err
+}
+
+func (h *fakeHandle) SetRecoveryActions(actions []RecoveryAction, resetPeriod uint32) error {
+	return h.do("SetRecoveryActions", func(s *fakeService) error {
+		s.RecoveryActions = append([]RecoveryAction(nil), actions...)
+		s.ResetPeriod = resetPeriod
+		return nil
+	})
+}
+
+func (h *fakeHandle) DependentServices() ([]string, error) {
+	var names []string
+	err := h.do("DependentServices", func(s *fakeService) error {
+		names = h.fake.dependents(h.name, map[string]bool{})
+		return nil
+	})
+	return names, err
+}
+
+func (h *fakeHandle) Environment() ([]string, error) {
+	var vars []string
+	err := h.do("Environment", func(s *fakeService) error {
+		vars = append(vars, s.Environment...)
+		return nil
+	})
+	return vars, err
+}
+
+func (h *fakeHandle) SetEnvironment(vars []string) error {
+	return h.do("SetEnvironment", func(s *fakeService) error {
+		s.Environment = append([]string(nil), vars...)
+		return nil
+	})
+}
+
+func (h *fakeHandle) Value(name string) (string, error) {
+	var value string
+	err := h.do("Value", func(s *fakeService) error {
+		v, ok := s.Values[name]
+		if !ok {
+			return fmt.Errorf("value %s of service %s: %w", name, h.name, ErrNotExist)
+		}
+		value = v
+		return nil
+	})
+	return value, err
+}
+
+func (h *fakeHandle) SetValue(name, value string) error {
+	return h.do("SetValue", func(s *fakeService) error {
+		s.Values[name] = value
+		return nil
+	})
+}
+
+func (h *fakeHandle) Delete() error {
+	return h.do("Delete", func(s *fakeService) error {
+		if s.MarkedForDelete {
+			return fmt.Errorf("%s: %w", h.name, ErrMarkedForDelete)
+		}
+		s.MarkedForDelete = true
+		if s.Status.State == Stopped {
+			delete(h.fake.services, h.name)
+		}
+		return nil
+	})
+}
+
+func (h *fakeHandle) Close() error {
+	return nil
+}
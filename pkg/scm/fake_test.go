@@ -0,0 +1,95 @@
+package scm
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFakeStateTransitions(t *testing.T) {
+	f := NewFake()
+	f.PendingQueries = 2
+
+	s, err := f.CreateService("exporter", `c:\bin\exporter.exe`, Config{StartType: StartAutomatic}, "--port", "9182")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := f.CreateService("exporter", `c:\bin\exporter.exe`, Config{}); !errors.Is(err, ErrExists) {
+		t.Errorf("expected ErrExists creating the service twice, got %v", err)
+	}
+
+	if err := s.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectStates(t, s, StartPending, StartPending, Running)
+
+	if _, err := s.Control(Stop); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectStates(t, s, StopPending, StopPending, Stopped)
+	if _, err := s.Control(Stop); !errors.Is(err, ErrNotActive) {
+		t.Errorf("expected ErrNotActive stopping a stopped service, got %v", err)
+	}
+
+	f.SetFailToStart("exporter", true)
+	if err := s.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectStates(t, s, StartPending, StartPending, Stopped)
+
+	if err := s.Delete(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := f.OpenService("exporter"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("expected ErrNotExist opening a deleted service, got %v", err)
+	}
+}
+
+func TestFakeDependencies(t *testing.T) {
+	f := NewFake()
+	f.Add("rancher-wins", Config{}, Stopped)
+	f.Add("rke2", Config{Dependencies: []string{"rancher-wins"}}, Stopped)
+
+	rke2, err := f.OpenService("rke2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := rke2.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if wins, _ := f.Service("rancher-wins"); wins.Status.State != Running {
+		t.Errorf("expected the dependency to be started, got state %s", wins.Status.State)
+	}
+
+	wins, err := f.OpenService("rancher-wins")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := wins.Control(Stop); !errors.Is(err, ErrDependentServicesRunning) {
+		t.Errorf("expected ErrDependentServicesRunning, got %v", err)
+	}
+
+	f.Fail("rke2", "Control", ErrNotActive)
+	if _, err := rke2.Control(Stop); !errors.Is(err, ErrNotActive) {
+		t.Errorf("expected the injected failure, got %v", err)
+	}
+	f.Fail("rke2", "Control", nil)
+	if _, err := rke2.Control(Stop); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := wins.Control(Stop); err != nil {
+		t.Errorf("expected the dependency to stop after the dependent service, got %v", err)
+	}
+}
+
+func expectStates(t *testing.T, s Service, states ...State) {
+	t.Helper()
+	for _, expected := range states {
+		status, err := s.Query()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if status.State != expected {
+			t.Fatalf("expected state %s, got %s", expected, status.State)
+		}
+	}
+}
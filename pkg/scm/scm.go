@@ -0,0 +1,162 @@
+// Package scm is the backend wins uses to manage Windows services. Manager is implemented by the service
+// control manager of the host, see System, and by Fake, an in-memory service manager for tests that
+// models state transitions, dependencies and failures, so that the logic built on top of it can be
+// tested on any platform.
+package scm
+
+import (
+	"errors"
+	"time"
+)
+
+// State is the current state of a service, the values match the Windows SERVICE_* states.
+type State uint32
+
+const (
+	Stopped         State = 1
+	StartPending    State = 2
+	StopPending     State = 3
+	Running         State = 4
+	ContinuePending State = 5
+	PausePending    State = 6
+	Paused          State = 7
+)
+
+func (s State) String() string {
+	switch s {
+	case Stopped:
+		return "Stopped"
+	case StartPending:
+		return "Start Pending"
+	case StopPending:
+		return "Stop Pending"
+	case Running:
+		return "Running"
+	case ContinuePending:
+		return "Continue Pending"
+	case PausePending:
+		return "Pause Pending"
+	case Paused:
+		return "Paused"
+	default:
+		return "Unknown State"
+	}
+}
+
+// Cmd is a control request sent to a service, the values match the Windows SERVICE_CONTROL_* codes.
+type Cmd uint32
+
+const (
+	Stop        Cmd = 1
+	Interrogate Cmd = 4
+)
+
+// Status is the status of a service as reported to the service manager.
+type Status struct {
+	State State
+	// CheckPoint is incremented by a service while it makes progress during a pending state.
+	CheckPoint uint32
+	// WaitHint is the time the service expects the current pending step to take.
+	WaitHint      time.Duration
+	ProcessID     uint32
+	Win32ExitCode uint32
+	// ServiceSpecificExitCode is set by services that stopped with Win32ExitCode ERROR_SERVICE_SPECIFIC_ERROR.
+	ServiceSpecificExitCode uint32
+}
+
+const (
+	// ServiceWin32OwnProcess is a service that runs in its own process.
+	ServiceWin32OwnProcess = 0x10
+
+	StartAutomatic = 2
+	StartManual    = 3
+	StartDisabled  = 4
+
+	ErrorNormal = 1
+)
+
+// Config is the configuration of a service.
+type Config struct {
+	ServiceType  uint32
+	StartType    uint32
+	ErrorControl uint32
+	// BinaryPathName is the command line of the service, see JoinCommandLine.
+	BinaryPathName   string
+	Dependencies     []string
+	DisplayName      string
+	Description      string
+	DelayedAutoStart bool
+}
+
+// RecoveryAction types, the values match the Windows SC_ACTION_* types.
+const (
+	NoAction       = 0
+	ServiceRestart = 1
+)
+
+// RecoveryAction is an action the service manager takes when a service fails.
+type RecoveryAction struct {
+	Type  int
+	Delay time.Duration
+}
+
+var (
+	// ErrNotExist is returned for services, and values of services, that do not exist.
+	ErrNotExist = errors.New("service does not exist")
+	// ErrExists is returned when creating a service that already exists.
+	ErrExists = errors.New("service already exists")
+	// ErrMarkedForDelete is returned for services that are deleted once they have stopped.
+	ErrMarkedForDelete = errors.New("service is marked for deletion")
+	// ErrAlreadyRunning is returned when starting a service that is already running.
+	ErrAlreadyRunning = errors.New("service is already running")
+	// ErrNotActive is returned when sending a control request to a service that is not running.
+	ErrNotActive = errors.New("service is not active")
+	// ErrDependentServicesRunning is returned when stopping a service that running services depend on.
+	ErrDependentServicesRunning = errors.New("services that depend on the service are running")
+	// ErrDependencyFailed is returned when a service cannot be started because a dependency did not start.
+	ErrDependencyFailed = errors.New("a dependency of the service failed to start")
+	// ErrAccessDenied is returned when the caller lacks the rights for an operation.
+	ErrAccessDenied = errors.New("access denied")
+	// ErrUnsupported is returned by the service manager of platforms without Windows services.
+	ErrUnsupported = errors.New("windows services are not supported on this platform")
+)
+
+// Manager creates and opens services.
+type Manager interface {
+	// CreateService creates a service that runs exePath with args, which are appended to the BinaryPathName.
+	CreateService(name, exePath string, cfg Config, args ...string) (Service, error)
+	// OpenService opens an existing service, returning ErrNotExist if there is none.
+	OpenService(name string) (Service, error)
+	// RemoveServiceKey removes the registry key left behind by a deleted service, which holds its environment and
+	// values, returning ErrNotExist if there is none.
+	RemoveServiceKey(name string) error
+	// RemoveEventSource removes the event log source of a service, returning ErrNotExist if there is none.
+	RemoveEventSource(name string) error
+}
+
+// Service is an open service, which must be closed by the caller.
+type Service interface {
+	Name() string
+	Query() (Status, error)
+	Start(args ...string) error
+	Control(cmd Cmd) (Status, error)
+	Config() (Config, error)
+	UpdateConfig(cfg Config) error
+	RecoveryActions() ([]RecoveryAction, error)
+	// ResetPeriod is the number of seconds without failures after which the failure count is reset.
+	ResetPeriod() (uint32, error)
+	SetRecoveryActions(actions []RecoveryAction, resetPeriod uint32) error
+	// DependentServices returns the active services that depend on the service, directly or indirectly,
+	// in the order they have to be stopped.
+	DependentServices() ([]string, error)
+	// Environment returns the NAME=value environment variables of the service.
+	Environment() ([]string, error)
+	// SetEnvironment replaces the environment variables of the service, an empty list removes them.
+	SetEnvironment(vars []string) error
+	// Value returns a string value stored with the service, or ErrNotExist.
+	Value(name string) (string, error)
+	SetValue(name, value string) error
+	// Delete marks the service for deletion, it is removed once it has stopped.
+	Delete() error
+	Close() error
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+
+package scm
+
+// System returns a Manager that fails with ErrUnsupported, as there is no service control manager.
+func System() Manager {
+	return unsupportedManager{}
+}
+
+type unsupportedManager struct{}
+
+func (unsupportedManager) CreateService(string, string, Config, ...string) (Service, error) {
+	return nil, ErrUnsupported
+}
+
+func (unsupportedManager) OpenService(string) (Service, error) {
+	return nil, ErrUnsupported
+}
+
+func (unsupportedManager) RemoveServiceKey(string) error {
+	return ErrUnsupported
+}
+
+func (unsupportedManager) RemoveEventSource(string) error {
+	return ErrUnsupported
+}
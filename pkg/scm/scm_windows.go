@@ -0,0 +1,310 @@
+package scm
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const (
+	environmentValue = "Environment"
+	servicesKey      = `SYSTEM\CurrentControlSet\Services\`
+)
+
+// System returns the service control manager of the host. It connects for every service that is created
+// or opened, the returned services stay valid until they are closed.
+func System() Manager {
+	return systemManager{}
+}
+
+type systemManager struct{}
+
+func (systemManager) CreateService(name, exePath string, cfg Config, args ...string) (Service, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return nil, fmt.Errorf("could not open SCM: %w", err)
+	}
+	defer func() {
+		_ = m.Disconnect()
+	}()
+
+	s, err := m.CreateService(name, exePath, mgr.Config{
+		ServiceType:      cfg.ServiceType,
+		StartType:        cfg.StartType,
+		ErrorControl:     cfg.ErrorControl,
+		Dependencies:     cfg.Dependencies,
+		DisplayName:      cfg.DisplayName,
+		Description:      cfg.Description,
+		DelayedAutoStart: cfg.DelayedAutoStart,
+	}, args...)
+	if err != nil {
+		return nil, translate(err)
+	}
+	return &systemService{s: s}, nil
+}
+
+func (systemManager) OpenService(name string) (Service, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return nil, fmt.Errorf("could not open SCM: %w", err)
+	}
+	defer func() {
+		_ = m.Disconnect()
+	}()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return nil, translate(err)
+	}
+	return &systemService{s: s}, nil
+}
+
+// RemoveServiceKey removes the registry key of the service with its subkeys. It must only be called once the
+// service is not listed by the service manager anymore.
+func (systemManager) RemoveServiceKey(name string) error {
+	err := deleteKey(registry.LOCAL_MACHINE, servicesKey+name)
+	if errors.Is(err, registry.ErrNotExist) {
+		return fmt.Errorf("registry key of service %s: %w", name, ErrNotExist)
+	}
+	return translate(err)
+}
+
+func (systemManager) RemoveEventSource(name string) error {
+	err := eventlog.Remove(name)
+	if errors.Is(err, registry.ErrNotExist) {
+		return fmt.Errorf("event log source %s: %w", name, ErrNotExist)
+	}
+	return translate(err)
+}
+
+// deleteKey deletes the registry key at path below parent, deleting its subkeys first as registry.DeleteKey fails
+// for keys with subkeys.
+func deleteKey(parent registry.Key, path string) error {
+	k, err := registry.OpenKey(parent, path, registry.ENUMERATE_SUB_KEYS)
+	if err != nil {
+		return err
+	}
+	names, err := k.ReadSubKeyNames(-1)
+	k.Close()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := deleteKey(parent, path+`\`+name); err != nil {
+			return err
+		}
+	}
+	return registry.DeleteKey(parent, path)
+}
+
+type systemService struct {
+	s *mgr.Service
+}
+
+func (s *systemService) Name() string {
+	return s.s.Name
+}
+
+func (s *systemService) Query() (Status, error) {
+	status, err := s.s.Query()
+	if err != nil {
+		return Status{}, translate(err)
+	}
+	return convertStatus(status), nil
+}
+
+func (s *systemService) Start(args ...string) error {
+	return translate(s.s.Start(args...))
+}
+
+func (s *systemService) Control(cmd Cmd) (Status, error) {
+	status, err := s.s.Control(svc.Cmd(cmd))
+	if err != nil {
+		return Status{}, translate(err)
+	}
+	return convertStatus(status), nil
+}
+
+func (s *systemService) Config() (Config, error) {
+	c, err := s.s.Config()
+	if err != nil {
+		return Config{}, translate(err)
+	}
+	return Config{
+		ServiceType:      c.ServiceType,
+		StartType:        c.StartType,
+		ErrorControl:     c.ErrorControl,
+		BinaryPathName:   c.BinaryPathName,
+		Dependencies:     c.Dependencies,
+		DisplayName:      c.DisplayName,
+		Description:      c.Description,
+		DelayedAutoStart: c.DelayedAutoStart,
+	}, nil
+}
+
+// UpdateConfig applies cfg on top of the current configuration, so that settings Config does not
+// cover, such as the account of the service, are kept.
+func (s *systemService) UpdateConfig(cfg Config) error {
+	c, err := s.s.Config()
+	if err != nil {
+		return translate(err)
+	}
+	c.ServiceType = cfg.ServiceType
+	c.StartType = cfg.StartType
+	c.ErrorControl = cfg.ErrorControl
+	c.BinaryPathName = cfg.BinaryPathName
+	c.DisplayName = cfg.DisplayName
+	c.Description = cfg.Description
+	c.DelayedAutoStart = cfg.DelayedAutoStart
+	c.Dependencies = cfg.Dependencies
+	if len(c.Dependencies) == 0 {
+		// an empty list keeps the dependencies, "/" removes them
+		c.Dependencies = []string{"/"}
+	}
+	return translate(s.s.UpdateConfig(c))
+}
+
+func (s *systemService) RecoveryActions() ([]RecoveryAction, error) {
+	actions, err := s.s.RecoveryActions()
+	if err != nil {
+		return nil, translate(err)
+	}
+	var converted []RecoveryAction
+	for _, a := range actions {
+		converted = append(converted, RecoveryAction{Type: a.Type, Delay: a.Delay})
+	}
+	return converted, nil
+}
+
+func (s *systemService) ResetPeriod() (uint32, error) {
+	period, err := s.s.ResetPeriod()
+	return period, translate(err)
+}
+
+func (s *systemService) SetRecoveryActions(actions []RecoveryAction, resetPeriod uint32) error {
+	var converted []mgr.RecoveryAction
+	for _, a := range actions {
+		converted = append(converted, mgr.RecoveryAction{Type: a.Type, Delay: a.Delay})
+	}
+	return translate(s.s.SetRecoveryActions(converted, resetPeriod))
+}
+
+func (s *systemService) DependentServices() ([]string, error) {
+	names, err := s.s.ListDependentServices(svc.Active)
+	return names, translate(err)
+}
+
+func (s *systemService) Environment() ([]string, error) {
+	k, err := s.openKey(registry.QUERY_VALUE)
+	if err != nil {
+		return nil, err
+	}
+	defer k.Close()
+
+	vars, _, err := k.GetStringsValue(environmentValue)
+	if errors.Is(err, registry.ErrNotExist) {
+		return nil, nil
+	}
+	return vars, err
+}
+
+func (s *systemService) SetEnvironment(vars []string) error {
+	k, err := s.openKey(registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	defer k.Close()
+
+	if len(vars) == 0 {
+		if err := k.DeleteValue(environmentValue); err != nil && !errors.Is(err, registry.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	return k.SetStringsValue(environmentValue, vars)
+}
+
+func (s *systemService) Value(name string) (string, error) {
+	k, err := s.openKey(registry.QUERY_VALUE)
+	if err != nil {
+		return "", err
+	}
+	defer k.Close()
+
+	value, _, err := k.GetStringValue(name)
+	if errors.Is(err, registry.ErrNotExist) {
+		return "", fmt.Errorf("value %s of service %s: %w", name, s.s.Name, ErrNotExist)
+	}
+	return value, err
+}
+
+func (s *systemService) SetValue(name, value string) error {
+	k, err := s.openKey(registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	defer k.Close()
+
+	return k.SetStringValue(name, value)
+}
+
+func (s *systemService) Delete() error {
+	return translate(s.s.Delete())
+}
+
+func (s *systemService) Close() error {
+	return s.s.Close()
+}
+
+// openKey opens the registry key of the service, which holds its environment and values.
+func (s *systemService) openKey(access uint32) (registry.Key, error) {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, servicesKey+s.s.Name, access)
+	if err != nil {
+		return 0, fmt.Errorf("error opening the registry key of service %s: %w", s.s.Name, translate(err))
+	}
+	return k, nil
+}
+
+func convertStatus(status svc.Status) Status {
+	return Status{
+		State:         State(status.State),
+		CheckPoint:    status.CheckPoint,
+		WaitHint:      time.Duration(status.WaitHint) * time.Millisecond,
+		ProcessID:     status.ProcessId,
+		Win32ExitCode: status.Win32ExitCode,
+
+		ServiceSpecificExitCode: status.ServiceSpecificExitCode,
+	}
+}
+
+var sentinels = map[windows.Errno]error{
+	windows.ERROR_SERVICE_DOES_NOT_EXIST:     ErrNotExist,
+	windows.ERROR_SERVICE_EXISTS:             ErrExists,
+	windows.ERROR_SERVICE_MARKED_FOR_DELETE:  ErrMarkedForDelete,
+	windows.ERROR_SERVICE_ALREADY_RUNNING:    ErrAlreadyRunning,
+	windows.ERROR_SERVICE_NOT_ACTIVE:         ErrNotActive,
+	windows.ERROR_DEPENDENT_SERVICES_RUNNING: ErrDependentServicesRunning,
+	windows.ERROR_SERVICE_DEPENDENCY_FAIL:    ErrDependencyFailed,
+	windows.ERROR_SERVICE_DEPENDENCY_DELETED: ErrDependencyFailed,
+	windows.ERROR_SERVICE_CANNOT_ACCEPT_CTRL: ErrNotActive,
+	windows.ERROR_ACCESS_DENIED:              ErrAccessDenied,
+}
+
+// translate wraps Windows errors with the matching error of this package, so that callers can check them
+// on any platform. The Windows error is kept in the chain.
+func translate(err error) error {
+	var errno windows.Errno
+	if err == nil || !errors.As(err, &errno) {
+		return err
+	}
+	if sentinel, ok := sentinels[errno]; ok {
+		return fmt.Errorf("%w: %w", sentinel, err)
+	}
+	return err
+}
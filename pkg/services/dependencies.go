@@ -0,0 +1,414 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/rancher/wins/pkg/scm"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// managedServicesValue records on the owner the services whose dependencies it manages.
+	managedServicesValue = "ManagedDependencyServices"
+	// managedDependenciesValue records on a service the dependencies that were declared for it, so that only
+	// those are removed when they are not declared anymore.
+	managedDependenciesValue = "ManagedDependencies"
+	// suspendedDependentsValue records on a service the services whose dependency on it was removed while it
+	// was restarted, so that the dependencies are restored even if the restart was interrupted.
+	suspendedDependentsValue = "SuspendedDependents"
+	// valueSeparator separates the service names in the values, it cannot be part of a service name.
+	valueSeparator = "/"
+)
+
+// Dependency declares that a service depends on other services. The service manager starts the services it
+// depends on before it, and it cannot be stopped while services that depend on it are running.
+type Dependency struct {
+	Service   string   `yaml:"service" json:"service"`
+	DependsOn []string `yaml:"dependsOn" json:"dependsOn"`
+}
+
+// ValidateDependencies ensures that the dependencies name valid services and do not form a cycle.
+func ValidateDependencies(deps []Dependency) error {
+	for _, d := range deps {
+		for _, name := range append([]string{d.Service}, d.DependsOn...) {
+			if name == "" || strings.ContainsAny(name, `/\`) {
+				return fmt.Errorf("invalid service name %q", name)
+			}
+		}
+	}
+
+	graph := map[string][]string{}
+	var services []string
+	for _, d := range mergeDependencies(deps) {
+		service := strings.ToLower(d.Service)
+		services = append(services, service)
+		for _, dep := range d.DependsOn {
+			graph[service] = append(graph[service], strings.ToLower(dep))
+		}
+	}
+
+	visited := map[string]bool{}
+	for _, name := range services {
+		if cycle := findCycle(graph, name, visited, nil); cycle != nil {
+			return fmt.Errorf("circular service dependency %s", strings.Join(cycle, " -> "))
+		}
+	}
+	return nil
+}
+
+// mergeDependencies merges the dependencies of the same service, ignoring the case of the names like the service
+// manager does.
+func mergeDependencies(deps []Dependency) []Dependency {
+	var merged []Dependency
+	for _, d := range deps {
+		i := slices.IndexFunc(merged, func(m Dependency) bool {
+			return strings.EqualFold(m.Service, d.Service)
+		})
+		if i < 0 {
+			merged = append(merged, Dependency{Service: d.Service})
+			i = len(merged) - 1
+		}
+		for _, dep := range d.DependsOn {
+			if !containsFold(merged[i].DependsOn, dep) {
+				merged[i].DependsOn = append(merged[i].DependsOn, dep)
+			}
+		}
+	}
+	return merged
+}
+
+// findCycle returns the services of a cycle that is reachable from name, nil if there is none.
+func findCycle(graph map[string][]string, name string, visited map[string]bool, path []string) []string {
+	if i := slices.Index(path, name); i >= 0 {
+		return append(slices.Clone(path[i:]), name)
+	}
+	if visited[name] {
+		return nil
+	}
+	path = append(path, name)
+	for _, dep := range graph[name] {
+		if cycle := findCycle(graph, dep, visited, path); cycle != nil {
+			return cycle
+		}
+	}
+	visited[name] = true
+	return nil
+}
+
+// findLiveCycle returns the path from name to target through the configured dependencies of the services, nil
+// if target is not reached.
+func findLiveCycle(manager scm.Manager, name, target string, path []string) ([]string, error) {
+	s, err := Open(manager, name)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	dependencies := s.Config.Dependencies
+	s.Close()
+
+	for _, dep := range dependencies {
+		if strings.EqualFold(dep, target) {
+			return append(path, dep), nil
+		}
+		if containsFold(path, dep) {
+			continue
+		}
+		cycle, err := findLiveCycle(manager, dep, target, append(slices.Clone(path), dep))
+		if cycle != nil || err != nil {
+			return cycle, err
+		}
+	}
+	return nil, nil
+}
+
+// ReconcileDependencies adds the declared dependencies to the existing services, and removes the dependencies that
+// were declared before but are not anymore. Dependencies that were not declared are kept. The services whose
+// dependencies are managed are recorded on the owner service, so that their dependencies are removed once they are
+// not declared at all. Dependencies on the owner that were suspended by an interrupted RestartWithoutDependents are
+// restored first.
+func ReconcileDependencies(manager scm.Manager, owner string, deps []Dependency) error {
+	if err := ValidateDependencies(deps); err != nil {
+		return err
+	}
+
+	o, err := Open(manager, owner)
+	if err != nil {
+		return err
+	}
+	defer o.Close()
+
+	if err := o.RestoreDependents(); err != nil {
+		return err
+	}
+
+	declared := mergeDependencies(deps)
+	previous, err := listValue(o, managedServicesValue)
+	if err != nil {
+		return err
+	}
+	for _, name := range previous {
+		if !slices.ContainsFunc(declared, func(d Dependency) bool { return strings.EqualFold(d.Service, name) }) {
+			declared = append(declared, Dependency{Service: name})
+		}
+	}
+
+	var errs []error
+	var managed []string
+	for _, d := range declared {
+		err := reconcileDependencies(manager, d.Service, d.DependsOn)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		// services that failed are kept, so that their dependencies are cleaned up later
+		if len(d.DependsOn) > 0 || err != nil {
+			managed = append(managed, d.Service)
+		}
+	}
+	if err := o.SetValue(managedServicesValue, strings.Join(managed, valueSeparator)); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func reconcileDependencies(manager scm.Manager, name string, desired []string) error {
+	s, err := Open(manager, name)
+	if errors.Is(err, ErrNotFound) {
+		if len(desired) > 0 {
+			logrus.Infof("Service %s does not exist, its dependencies are configured once it is installed", name)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	managed, err := listValue(s, managedDependenciesValue)
+	if err != nil {
+		return err
+	}
+
+	var dependencies []string
+	for _, dep := range s.Config.Dependencies {
+		if containsFold(dependencies, dep) || (containsFold(managed, dep) && !containsFold(desired, dep)) {
+			continue
+		}
+		dependencies = append(dependencies, dep)
+	}
+	for _, dep := range desired {
+		if containsFold(dependencies, dep) {
+			continue
+		}
+		// a dependency on a missing service would prevent the service from starting
+		exists, err := Exists(manager, dep)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			logrus.Infof("Service %s does not exist, the dependency of %s on it is configured once it is installed", dep, name)
+			continue
+		}
+		cycle, err := findLiveCycle(manager, dep, name, []string{name, dep})
+		if err != nil {
+			return err
+		}
+		if cycle != nil {
+			return fmt.Errorf("the dependency of %s on %s would create the circular service dependency %s", name, dep, strings.Join(cycle, " -> "))
+		}
+		dependencies = append(dependencies, dep)
+	}
+
+	if !equalFold(dependencies, s.Config.Dependencies) {
+		logrus.Infof("Updating the dependencies of service %s from %v to %v", name, s.Config.Dependencies, dependencies)
+		s.Config.Dependencies = dependencies
+		if err := s.UpdateConfig(); err != nil {
+			return err
+		}
+	}
+	return s.SetValue(managedDependenciesValue, strings.Join(desired, valueSeparator))
+}
+
+// RestartWithoutDependents restarts the Service while the services that depend on it keep running. Their dependency
+// on the Service is suspended for the restart: the dependents are recorded on the Service before their dependency is
+// removed, and it is restored afterwards, or by RestoreDependents if the restart is interrupted.
+func (s *Service) RestartWithoutDependents(ctx context.Context) error {
+	names, err := s.svc.DependentServices()
+	if err != nil {
+		return newError(s.Name, "list the dependent services of", err)
+	}
+
+	var dependents []string
+	for _, name := range names {
+		d, err := Open(s.manager, name)
+		if err != nil {
+			return err
+		}
+		// the dependents of the dependents keep depending on them
+		if containsFold(d.Config.Dependencies, s.Name) {
+			dependents = append(dependents, name)
+		}
+		d.Close()
+	}
+
+	err = s.suspendDependents(dependents)
+	if err == nil {
+		logrus.Infof("Restarting %s service", s.Name)
+		err = s.Stop(ctx)
+		if err == nil {
+			err = s.Start(ctx)
+		}
+	}
+	return errors.Join(err, s.RestoreDependents())
+}
+
+// suspendDependents records the dependents and removes their dependency on the Service.
+func (s *Service) suspendDependents(dependents []string) error {
+	suspended, err := listValue(s, suspendedDependentsValue)
+	if err != nil {
+		return err
+	}
+	for _, name := range dependents {
+		if !containsFold(suspended, name) {
+			suspended = append(suspended, name)
+		}
+	}
+	if err := s.SetValue(suspendedDependentsValue, strings.Join(suspended, valueSeparator)); err != nil {
+		return err
+	}
+
+	for _, name := range dependents {
+		d, err := Open(s.manager, name)
+		if err != nil {
+			return err
+		}
+		logrus.Infof("Temporarily removing the dependency of %s on %s", name, s.Name)
+		d.Config.Dependencies = slices.DeleteFunc(slices.Clone(d.Config.Dependencies), func(dep string) bool {
+			return strings.EqualFold(dep, s.Name)
+		})
+		err = d.UpdateConfig()
+		d.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreDependents restores the dependencies on the Service that were suspended by RestartWithoutDependents.
+func (s *Service) RestoreDependents() error {
+	suspended, err := listValue(s, suspendedDependentsValue)
+	if err != nil || len(suspended) == 0 {
+		return err
+	}
+
+	var failed []string
+	var errs []error
+	for _, name := range suspended {
+		if err := s.restoreDependent(name); err != nil {
+			failed = append(failed, name)
+			errs = append(errs, err)
+		}
+	}
+	if err := s.SetValue(suspendedDependentsValue, strings.Join(failed, valueSeparator)); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Service) restoreDependent(name string) error {
+	d, err := Open(s.manager, name)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	if containsFold(d.Config.Dependencies, s.Name) {
+		return nil
+	}
+	logrus.Infof("Restoring the dependency of %s on %s", name, s.Name)
+	d.Config.Dependencies = append(d.Config.Dependencies, s.Name)
+	return d.UpdateConfig()
+}
+
+// listValue returns the service names stored in a value of the service, nil if there is none.
+func listValue(s *Service, name string) ([]string, error) {
+	value, err := s.Value(name)
+	if errors.Is(err, ErrNotFound) || value == "" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(value, valueSeparator), nil
+}
+
+func containsFold(names []string, name string) bool {
+	return slices.ContainsFunc(names, func(n string) bool {
+		return strings.EqualFold(n, name)
+	})
+}
+
+// equalFold reports whether both lists contain the same service names, ignoring their order and case.
+func equalFold(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, name := range a {
+		if !containsFold(b, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// recordedValues are the values the dependency management records on a service.
+var recordedValues = []string{managedServicesValue, managedDependenciesValue, suspendedDependentsValue}
+
+// RecordedValues returns the values the dependency management recorded on the named service, so that they can be
+// restored with RestoreRecordedValues when the service is registered again. A missing service has no values.
+func RecordedValues(manager scm.Manager, name string) (map[string]string, error) {
+	s, err := Open(manager, name)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	values := map[string]string{}
+	for _, v := range recordedValues {
+		value, err := s.Value(v)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[v] = value
+	}
+	return values, nil
+}
+
+// RestoreRecordedValues records the values returned by RecordedValues on the service again.
+func RestoreRecordedValues(s scm.Service, values map[string]string) error {
+	for _, v := range recordedValues {
+		value, ok := values[v]
+		if !ok {
+			continue
+		}
+		if err := s.SetValue(v, value); err != nil {
+			return fmt.Errorf("could not restore the %s value: %w", v, err)
+		}
+	}
+	return nil
+}
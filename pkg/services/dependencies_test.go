@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rancher/wins/pkg/scm"
+)
+
+func TestValidateDependencies(t *testing.T) {
+	testCases := []struct {
+		name  string
+		deps  []Dependency
+		cycle string
+	}{
+		{
+			name: "chain",
+			deps: []Dependency{
+				{Service: "rke2", DependsOn: []string{"rancher-wins"}},
+				{Service: "csiproxy", DependsOn: []string{"rke2", "containerd"}},
+			},
+		},
+		{
+			name: "cycle",
+			deps: []Dependency{
+				{Service: "rke2", DependsOn: []string{"rancher-wins"}},
+				{Service: "csiproxy", DependsOn: []string{"rke2"}},
+				{Service: "Rancher-Wins", DependsOn: []string{"csiproxy"}},
+			},
+			cycle: "rke2 -> rancher-wins -> csiproxy -> rke2",
+		},
+		{
+			name:  "self",
+			deps:  []Dependency{{Service: "rke2", DependsOn: []string{"rke2"}}},
+			cycle: "rke2 -> rke2",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateDependencies(tc.deps)
+			if tc.cycle == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tc.cycle != "" && (err == nil || !strings.Contains(err.Error(), tc.cycle)) {
+				t.Errorf("expected the cycle %s, got %v", tc.cycle, err)
+			}
+		})
+	}
+}
+
+func TestReconcileDependencies(t *testing.T) {
+	fake := scm.NewFake()
+	fake.Add("rancher-wins", scm.Config{}, scm.Running)
+	fake.Add("containerd", scm.Config{}, scm.Running)
+	fake.Add("rke2", scm.Config{Dependencies: []string{"other"}}, scm.Running)
+	fake.Add("csiproxy", scm.Config{}, scm.Running)
+	fake.Add("other", scm.Config{}, scm.Running)
+
+	deps := []Dependency{
+		{Service: "rke2", DependsOn: []string{"rancher-wins"}},
+		{Service: "csiproxy", DependsOn: []string{"rke2", "missing"}},
+		{Service: "rke2", DependsOn: []string{"containerd"}},
+	}
+	if err := ReconcileDependencies(fake, "rancher-wins", deps); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectDependencies(t, fake, "rke2", "other", "rancher-wins", "containerd")
+	expectDependencies(t, fake, "csiproxy", "rke2")
+
+	// the undeclared dependencies are removed, the ones that were never declared are kept
+	if err := ReconcileDependencies(fake, "rancher-wins", deps[:1]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectDependencies(t, fake, "rke2", "other", "rancher-wins")
+	expectDependencies(t, fake, "csiproxy")
+
+	if err := ReconcileDependencies(fake, "rancher-wins", []Dependency{{Service: "rancher-wins", DependsOn: []string{"rke2"}}}); err == nil {
+		t.Error("expected an error for a circular dependency")
+	}
+}
+
+func TestRestartWithoutDependents(t *testing.T) {
+	fake := scm.NewFake()
+	fake.Add("rancher-wins", scm.Config{}, scm.Running)
+	fake.Add("rke2", scm.Config{Dependencies: []string{"rancher-wins"}}, scm.Running)
+	fake.Add("kube-proxy", scm.Config{Dependencies: []string{"rke2"}}, scm.Running)
+
+	s, err := Open(fake, "rancher-wins")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer s.Close()
+
+	// an interrupted restart leaves the dependency suspended
+	fake.Fail("rancher-wins", "Start", scm.ErrAccessDenied)
+	if err := s.RestartWithoutDependents(context.Background()); err == nil {
+		t.Fatal("expected the restart to fail")
+	}
+	fake.Fail("rancher-wins", "Start", nil)
+	expectDependencies(t, fake, "rke2", "rancher-wins")
+
+	// a crash during the restart leaves the dependency suspended until the next reconciliation
+	if err := s.suspendDependents([]string{"rke2"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectDependencies(t, fake, "rke2")
+	if err := ReconcileDependencies(fake, "rancher-wins", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectDependencies(t, fake, "rke2", "rancher-wins")
+
+	if err := s.RestartWithoutDependents(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wins, _ := fake.Service("rancher-wins")
+	if wins.Status.State != scm.Running || wins.Starts != 1 {
+		t.Errorf("expected rancher-wins to be restarted, got state %s after %d starts", wins.Status.State, wins.Starts)
+	}
+	for _, name := range []string{"rke2", "kube-proxy"} {
+		if fs, _ := fake.Service(name); fs.Status.State != scm.Running || fs.Starts != 0 {
+			t.Errorf("expected %s to keep running, got state %s after %d starts", name, fs.Status.State, fs.Starts)
+		}
+	}
+	expectDependencies(t, fake, "rke2", "rancher-wins")
+}
+
+func TestRecordedValues(t *testing.T) {
+	fake := scm.NewFake()
+	fake.Add("rancher-wins", scm.Config{}, scm.Running)
+	fake.Add("rke2", scm.Config{Dependencies: []string{"rancher-wins"}}, scm.Stopped)
+	if err := ReconcileDependencies(fake, "rancher-wins", []Dependency{{Service: "rke2", DependsOn: []string{"rancher-wins"}}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wins, _ := fake.Service("rancher-wins")
+	wins.Values[suspendedDependentsValue] = "csiproxy"
+	wins.Values["Other"] = "value"
+
+	values, err := RecordedValues(fake, "rancher-wins")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// registering the service again replaces it with a service without values
+	if err := Remove(context.Background(), fake, "rancher-wins", Cleanup{}).Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s, err := fake.CreateService("rancher-wins", `c:\etc\rancher\wins\wins.exe`, scm.Config{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer s.Close()
+	if err := RestoreRecordedValues(s, values); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]string{managedServicesValue: "rke2", suspendedDependentsValue: "csiproxy"}
+	if wins, _ := fake.Service("rancher-wins"); !reflect.DeepEqual(wins.Values, expected) {
+		t.Errorf("expected the values %v, got %v", expected, wins.Values)
+	}
+
+	if values, err := RecordedValues(fake, "missing"); err != nil || len(values) != 0 {
+		t.Errorf("expected no values of a missing service, got %v, %v", values, err)
+	}
+}
+
+func expectDependencies(t *testing.T, fake *scm.Fake, name string, expected ...string) {
+	t.Helper()
+	s, _ := fake.Service(name)
+	if !reflect.DeepEqual(s.Config.Dependencies, expected) && (len(expected) > 0 || len(s.Config.Dependencies) > 0) {
+		t.Errorf("expected %s to depend on %v, got %v", name, expected, s.Config.Dependencies)
+	}
+}
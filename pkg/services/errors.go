@@ -0,0 +1,62 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rancher/wins/pkg/scm"
+)
+
+var (
+	// ErrNotFound is matched by errors for services that do not exist.
+	ErrNotFound = errors.New("service not found")
+	// ErrAccessDenied is matched by errors for operations the caller has no rights for.
+	ErrAccessDenied = errors.New("access denied")
+	// ErrTimeout is matched by errors for services that did not reach a state in time.
+	ErrTimeout = errors.New("timed out")
+)
+
+// Error is returned by all operations on services. It can be matched with errors.Is against
+// ErrNotFound, ErrAccessDenied and ErrTimeout, and against the errors of the scm package.
+type Error struct {
+	Service string
+	Op      string
+	Err     error
+
+	kind error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("failed to %s service %s: %v", e.Op, e.Service, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return e.kind != nil && target == e.kind
+}
+
+// newError wraps err in an Error, nil if err is nil. Errors that are already an Error are returned as is.
+func newError(service, op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+
+	e = &Error{Service: service, Op: op, Err: err}
+	switch {
+	case errors.Is(err, scm.ErrNotExist):
+		e.kind = ErrNotFound
+	case errors.Is(err, scm.ErrAccessDenied):
+		e.kind = ErrAccessDenied
+	case errors.Is(err, ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		e.kind = ErrTimeout
+	}
+	return e
+}
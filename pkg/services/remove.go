@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rancher/wins/pkg/scm"
+	"github.com/sirupsen/logrus"
+)
+
+// Cleanup lists what Remove removes together with a service.
+type Cleanup struct {
+	// EventSource is the event log source the service registered, if any.
+	EventSource string
+	// Paths are the files and directories installed for the service.
+	Paths []string
+}
+
+// Report lists what Remove removed and what it failed to remove.
+type Report struct {
+	Service string
+	Removed []string
+	Failed  []Failure
+}
+
+// Failure is an item that could not be removed.
+type Failure struct {
+	Item string
+	Err  error
+}
+
+func (r *Report) removed(item string) {
+	logrus.Debugf("Removed %s", item)
+	r.Removed = append(r.Removed, item)
+}
+
+func (r *Report) fail(item string, err error) {
+	logrus.Warnf("Failed to remove %s: %v", item, err)
+	r.Failed = append(r.Failed, Failure{Item: item, Err: err})
+}
+
+func (r *Report) String() string {
+	removed := "nothing"
+	if len(r.Removed) > 0 {
+		removed = strings.Join(r.Removed, ", ")
+	}
+	s := fmt.Sprintf("removal of service %s: removed %s", r.Service, removed)
+	for _, f := range r.Failed {
+		s += fmt.Sprintf("; failed to remove %s: %v", f.Item, f.Err)
+	}
+	return s
+}
+
+// Err joins the failures into one error, nil if everything was removed.
+func (r *Report) Err() error {
+	var errs []error
+	for _, f := range r.Failed {
+		errs = append(errs, fmt.Errorf("failed to remove %s: %w", f.Item, f.Err))
+	}
+	return errors.Join(errs...)
+}
+
+// Remove stops the named service and deletes it, and waits until the service manager does not list it anymore, as a
+// deleted service is only removed once all handles to it are closed. Afterwards the registry key of the service, the
+// event log source and the paths of the cleanup are removed. Nothing else is removed if the service cannot be
+// removed, as the files may still be in use. A service that does not exist is not a failure, the cleanup still runs.
+func Remove(ctx context.Context, manager scm.Manager, name string, cleanup Cleanup) *Report {
+	r := &Report{Service: name}
+	if !r.removeService(ctx, manager, name) {
+		return r
+	}
+
+	key := "registry key of service " + name
+	if err := manager.RemoveServiceKey(name); err == nil {
+		r.removed(key)
+	} else if !errors.Is(err, scm.ErrNotExist) {
+		r.fail(key, err)
+	}
+
+	if cleanup.EventSource != "" {
+		source := "event log source " + cleanup.EventSource
+		if err := manager.RemoveEventSource(cleanup.EventSource); err == nil {
+			r.removed(source)
+		} else if !errors.Is(err, scm.ErrNotExist) {
+			r.fail(source, err)
+		}
+	}
+
+	for _, path := range cleanup.Paths {
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			r.fail(path, err)
+			continue
+		}
+		r.removed(path)
+	}
+	return r
+}
+
+// removeService stops and deletes the service, reporting whether it is gone.
+func (r *Report) removeService(ctx context.Context, manager scm.Manager, name string) bool {
+	item := "service " + name
+	s, err := Open(manager, name)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		logrus.Debugf("service %s does not exist", name)
+		return true
+	case errors.Is(err, scm.ErrMarkedForDelete):
+		logrus.Infof("Service %s is already marked for deletion", name)
+	case err != nil:
+		r.fail(item, err)
+		return false
+	default:
+		err := s.Stop(ctx)
+		if err == nil {
+			logrus.Infof("Deleting %s service", name)
+			if err = s.Delete(); errors.Is(err, scm.ErrMarkedForDelete) {
+				err = nil
+			}
+		}
+		s.Close()
+		if err != nil {
+			r.fail(item, err)
+			return false
+		}
+	}
+
+	if err := waitForRemoval(ctx, manager, name); err != nil {
+		r.fail(item, err)
+		return false
+	}
+	r.removed(item)
+	return true
+}
+
+// waitForRemoval queries the service manager until it does not list the deleted service anymore, backing off
+// exponentially between queries.
+func waitForRemoval(ctx context.Context, manager scm.Manager, name string) error {
+	start := time.Now()
+	interval := initialPollInterval
+	for {
+		s, err := manager.OpenService(name)
+		if errors.Is(err, scm.ErrNotExist) {
+			logrus.Debugf("Service %s was removed after %s", name, time.Since(start).Round(time.Millisecond))
+			return nil
+		}
+		if err == nil {
+			_ = s.Close()
+		} else if !errors.Is(err, scm.ErrMarkedForDelete) {
+			return newError(name, "wait for the removal of", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return newError(name, "wait for the removal of", fmt.Errorf("the service is still listed after %s, it is removed once all handles to it are closed: %w",
+				time.Since(start).Round(time.Millisecond), ctx.Err()))
+		case <-time.After(interval):
+		}
+		interval = min(2*interval, maxPollInterval)
+	}
+}
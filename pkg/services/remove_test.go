@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rancher/wins/pkg/scm"
+)
+
+func TestRemove(t *testing.T) {
+	type testCase struct {
+		name    string
+		state   scm.State
+		setup   func(fake *scm.Fake)
+		removed []string
+		failed  []string
+		timeout bool
+	}
+
+	testCases := []testCase{
+		{
+			name:    "running service",
+			state:   scm.Running,
+			removed: []string{"service kubelet", "registry key of service kubelet", "event log source kubelet", "dir"},
+		},
+		{
+			name:    "missing service",
+			removed: []string{"registry key of service kubelet", "event log source kubelet", "dir"},
+		},
+		{
+			name:  "stop fails",
+			state: scm.Running,
+			setup: func(fake *scm.Fake) {
+				fake.Fail("kubelet", "Control", fmt.Errorf("kubelet: %w", scm.ErrAccessDenied))
+			},
+			failed: []string{"service kubelet"},
+		},
+		{
+			name:  "service is not removed",
+			state: scm.Stopped,
+			setup: func(fake *scm.Fake) {
+				fake.Fail("kubelet", "OpenService", fmt.Errorf("kubelet: %w", scm.ErrMarkedForDelete))
+			},
+			failed:  []string{"service kubelet"},
+			timeout: true,
+		},
+		{
+			name:  "event source removal fails",
+			state: scm.Stopped,
+			setup: func(fake *scm.Fake) {
+				fake.Fail("kubelet", "RemoveEventSource", fmt.Errorf("kubelet: %w", scm.ErrAccessDenied))
+			},
+			removed: []string{"service kubelet", "registry key of service kubelet", "dir"},
+			failed:  []string{"event log source kubelet"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := scm.NewFake()
+			if tc.state != 0 {
+				fake.Add("kubelet", scm.Config{}, tc.state)
+			}
+			fake.AddServiceKey("kubelet")
+			fake.AddEventSource("kubelet")
+			if tc.setup != nil {
+				tc.setup(fake)
+			}
+
+			root := t.TempDir()
+			dir := filepath.Join(root, "dir")
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			missing := filepath.Join(root, "missing")
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			report := Remove(ctx, fake, "kubelet", Cleanup{EventSource: "kubelet", Paths: []string{dir, missing}})
+
+			var removed, failed []string
+			for _, item := range report.Removed {
+				removed = append(removed, filepath.Base(item))
+			}
+			for _, f := range report.Failed {
+				failed = append(failed, f.Item)
+			}
+			if !reflect.DeepEqual(removed, tc.removed) {
+				t.Errorf("expected %v to be removed, got %v", tc.removed, removed)
+			}
+			if !reflect.DeepEqual(failed, tc.failed) {
+				t.Errorf("expected %v to fail, got %v", tc.failed, failed)
+			}
+			if (report.Err() != nil) != (len(tc.failed) > 0) {
+				t.Errorf("unexpected error: %v", report.Err())
+			}
+			if errors.Is(report.Err(), ErrTimeout) != tc.timeout {
+				t.Errorf("expected a timeout to be %t, got %v", tc.timeout, report.Err())
+			}
+			if _, err := os.Stat(dir); os.IsNotExist(err) != slices.Contains(tc.removed, "dir") {
+				t.Errorf("expected the removal of %s to be %t", dir, slices.Contains(tc.removed, "dir"))
+			}
+		})
+	}
+}
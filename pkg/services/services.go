@@ -0,0 +1,218 @@
+// Package services manages Windows services for the wins server and the SUC. All operations return an
+// *Error, and operations that change the state of a service wait until it reached the new state.
+package services
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rancher/wins/pkg/scm"
+	"github.com/sirupsen/logrus"
+)
+
+// Service is an open Windows service with its configuration. The caller is responsible for closing it.
+type Service struct {
+	Name string
+	// Config is the configuration of the service when it was opened or refreshed, it is saved with UpdateConfig.
+	Config scm.Config
+
+	manager scm.Manager
+	svc     scm.Service
+}
+
+// Open opens an existing service, returning an error that matches ErrNotFound if it does not exist.
+func Open(manager scm.Manager, name string) (*Service, error) {
+	logrus.Debugf("Opening %s service", name)
+	s, err := manager.OpenService(name)
+	if err != nil {
+		return nil, newError(name, "open", err)
+	}
+	return newService(manager, name, s)
+}
+
+// Create creates a service that runs exePath with args.
+func Create(manager scm.Manager, name, exePath string, cfg scm.Config, args ...string) (*Service, error) {
+	logrus.Debugf("Creating %s service", name)
+	s, err := manager.CreateService(name, exePath, cfg, args...)
+	if err != nil {
+		return nil, newError(name, "create", err)
+	}
+	return newService(manager, name, s)
+}
+
+func newService(manager scm.Manager, name string, s scm.Service) (*Service, error) {
+	cfg, err := s.Config()
+	if err != nil {
+		_ = s.Close()
+		return nil, newError(name, "query the config of", err)
+	}
+	return &Service{Name: name, Config: cfg, manager: manager, svc: s}, nil
+}
+
+// Exists reports whether the named service exists.
+func Exists(manager scm.Manager, name string) (bool, error) {
+	s, err := Open(manager, name)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	s.Close()
+	return true, nil
+}
+
+// Close closes the Service.
+func (s *Service) Close() {
+	_ = s.svc.Close()
+}
+
+// Status queries the current status of the Service.
+func (s *Service) Status() (scm.Status, error) {
+	status, err := s.svc.Query()
+	return status, newError(s.Name, "query", err)
+}
+
+// State queries the current state of the Service.
+func (s *Service) State() (scm.State, error) {
+	status, err := s.Status()
+	return status.State, err
+}
+
+// Start starts the Service unless it is already running, and waits until it is Running.
+func (s *Service) Start(ctx context.Context) error {
+	state, err := s.State()
+	if err != nil {
+		return err
+	}
+	if state == scm.Running {
+		return nil
+	}
+	if state != scm.StartPending {
+		logrus.Infof("Starting %s service", s.Name)
+		if err := s.svc.Start(); err != nil && !errors.Is(err, scm.ErrAlreadyRunning) {
+			return newError(s.Name, "start", err)
+		}
+	}
+	return s.WaitForState(ctx, scm.Running)
+}
+
+// Stop stops the Service unless it is already stopped, and waits until it is Stopped.
+func (s *Service) Stop(ctx context.Context) error {
+	state, err := s.State()
+	if err != nil {
+		return err
+	}
+	if state == scm.Stopped {
+		logrus.Debugf("service %s is already stopped", s.Name)
+		return nil
+	}
+	if state != scm.StopPending {
+		logrus.Infof("Stopping %s service", s.Name)
+		if _, err := s.svc.Control(scm.Stop); err != nil && !errors.Is(err, scm.ErrNotActive) {
+			return newError(s.Name, "stop", err)
+		}
+	}
+	return s.WaitForState(ctx, scm.Stopped)
+}
+
+// Restart stops and starts the Service. The running services that depend on it are stopped before it and
+// started again after it, as a service cannot be stopped while services that depend on it are running.
+func (s *Service) Restart(ctx context.Context) error {
+	dependents, err := s.svc.DependentServices()
+	if err != nil {
+		return newError(s.Name, "list the dependent services of", err)
+	}
+
+	var stopped []*Service
+	defer func() {
+		for _, d := range stopped {
+			d.Close()
+		}
+	}()
+	for _, name := range dependents {
+		d, err := Open(s.manager, name)
+		if err != nil {
+			return err
+		}
+		stopped = append(stopped, d)
+		logrus.Infof("Stopping %s, which depends on %s, to restart %s", name, s.Name, s.Name)
+		if err := d.Stop(ctx); err != nil {
+			return err
+		}
+	}
+
+	logrus.Infof("Restarting %s service", s.Name)
+	if err := s.Stop(ctx); err != nil {
+		return err
+	}
+	if err := s.Start(ctx); err != nil {
+		return err
+	}
+
+	// the dependents are started in the reverse order they were stopped in
+	for i := len(stopped) - 1; i >= 0; i-- {
+		if err := stopped[i].Start(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateConfig saves the Config of the Service.
+func (s *Service) UpdateConfig() error {
+	logrus.Debugf("Updating config for %s service: %+v", s.Name, s.Config)
+	return newError(s.Name, "update the config of", s.svc.UpdateConfig(s.Config))
+}
+
+// RefreshConfig updates the Config with the current configuration of the Service.
+func (s *Service) RefreshConfig() error {
+	cfg, err := s.svc.Config()
+	if err != nil {
+		return newError(s.Name, "query the config of", err)
+	}
+	s.Config = cfg
+	return nil
+}
+
+// Environment returns the NAME=value environment variables of the Service.
+func (s *Service) Environment() ([]string, error) {
+	vars, err := s.svc.Environment()
+	return vars, newError(s.Name, "read the environment of", err)
+}
+
+// SetEnvironment replaces the environment variables of the Service, an empty list removes them.
+func (s *Service) SetEnvironment(vars []string) error {
+	return newError(s.Name, "set the environment of", s.svc.SetEnvironment(vars))
+}
+
+// Value returns a string value stored with the Service, the error matches ErrNotFound if there is none.
+func (s *Service) Value(name string) (string, error) {
+	value, err := s.svc.Value(name)
+	return value, newError(s.Name, "read a value of", err)
+}
+
+// SetValue stores a string value with the Service.
+func (s *Service) SetValue(name, value string) error {
+	return newError(s.Name, "set a value of", s.svc.SetValue(name, value))
+}
+
+// RecoveryActions returns the recovery actions of the Service and their reset period in seconds.
+func (s *Service) RecoveryActions() ([]scm.RecoveryAction, uint32, error) {
+	actions, err := s.svc.RecoveryActions()
+	if err != nil {
+		return nil, 0, newError(s.Name, "query the recovery actions of", err)
+	}
+	period, err := s.svc.ResetPeriod()
+	return actions, period, newError(s.Name, "query the recovery reset period of", err)
+}
+
+// SetRecoveryActions replaces the recovery actions of the Service.
+func (s *Service) SetRecoveryActions(actions []scm.RecoveryAction, resetPeriod uint32) error {
+	return newError(s.Name, "set the recovery actions of", s.svc.SetRecoveryActions(actions, resetPeriod))
+}
+
+// Delete marks the Service for deletion, it is removed once it has stopped.
+func (s *Service) Delete() error {
+	return newError(s.Name, "delete", s.svc.Delete())
+}
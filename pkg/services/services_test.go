@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rancher/wins/pkg/scm"
+)
+
+func TestRestart(t *testing.T) {
+	fake := scm.NewFake()
+	fake.Add("containerd", scm.Config{}, scm.Running)
+	fake.Add("kubelet", scm.Config{Dependencies: []string{"containerd"}}, scm.Running)
+	fake.Add("kube-proxy", scm.Config{Dependencies: []string{"kubelet"}}, scm.Running)
+	fake.Add("exporter", scm.Config{Dependencies: []string{"containerd"}}, scm.Stopped)
+
+	s, err := Open(fake, "containerd")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer s.Close()
+
+	if err := s.Restart(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for name, state := range map[string]scm.State{
+		"containerd": scm.Running,
+		"kubelet":    scm.Running,
+		"kube-proxy": scm.Running,
+		"exporter":   scm.Stopped,
+	} {
+		if fs, _ := fake.Service(name); fs.Status.State != state {
+			t.Errorf("expected %s to be %s, got %s", name, state, fs.Status.State)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	fake := scm.NewFake()
+	fake.Add("kubelet", scm.Config{}, scm.Stopped)
+
+	_, err := Open(fake, "missing")
+	if !errors.Is(err, ErrNotFound) || !errors.Is(err, scm.ErrNotExist) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+
+	s, err := Open(fake, "kubelet")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer s.Close()
+
+	fake.Fail("kubelet", "Start", fmt.Errorf("kubelet: %w", scm.ErrAccessDenied))
+	if err := s.Start(context.Background()); !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("expected an access denied error, got %v", err)
+	}
+	fake.Fail("kubelet", "Start", nil)
+
+	fake.PendingQueries = 1000
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = s.Start(ctx)
+	var serviceErr *Error
+	if !errors.Is(err, ErrTimeout) || !errors.As(err, &serviceErr) || serviceErr.Service != "kubelet" {
+		t.Errorf("expected a timeout error for kubelet, got %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rancher/wins/pkg/scm"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// initialPollInterval is the delay before the state is queried again for the first time, the delay doubles
+	// after every query up to maxPollInterval.
+	initialPollInterval = 100 * time.Millisecond
+	maxPollInterval     = 5 * time.Second
+)
+
+// Observation is a state of a service seen while waiting for it.
+type Observation struct {
+	State      scm.State
+	CheckPoint uint32
+	// Elapsed is the time since the wait started.
+	Elapsed time.Duration
+}
+
+func (o Observation) String() string {
+	if pending(o.State) {
+		return fmt.Sprintf("%s (checkpoint %d) after %s", o.State, o.CheckPoint, o.Elapsed.Round(time.Millisecond))
+	}
+	return fmt.Sprintf("%s after %s", o.State, o.Elapsed.Round(time.Millisecond))
+}
+
+// TimeoutError is returned when a service did not reach a state before the context expired, or when it stalled
+// in a pending state. It matches ErrTimeout.
+type TimeoutError struct {
+	Desired scm.State
+	// Stalled is set if the service did not advance its checkpoint within its wait hint.
+	Stalled bool
+	// History are the states the service was seen in, in order.
+	History []Observation
+}
+
+func (e *TimeoutError) Error() string {
+	reason := "timed out waiting"
+	if e.Stalled {
+		reason = "stalled"
+	}
+	states := make([]string, 0, len(e.History))
+	for _, o := range e.History {
+		states = append(states, o.String())
+	}
+	return fmt.Sprintf("%s before reaching state %s, seen states: %s", reason, e.Desired, strings.Join(states, ", "))
+}
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+// ExitError is returned when a service stopped while waiting for it to run, e.g. because it failed on start.
+type ExitError struct {
+	Win32ExitCode           uint32
+	ServiceSpecificExitCode uint32
+	// History are the states the service was seen in, in order.
+	History []Observation
+}
+
+func (e *ExitError) Error() string {
+	states := make([]string, 0, len(e.History))
+	for _, o := range e.History {
+		states = append(states, o.String())
+	}
+	return fmt.Sprintf("stopped with exit code %d and service specific exit code %d before reaching state %s, seen states: %s",
+		e.Win32ExitCode, e.ServiceSpecificExitCode, scm.Running, strings.Join(states, ", "))
+}
+
+// WaitForState queries the Service until it reaches the desired state, backing off exponentially between
+// queries. While the service is pending, the queries follow its wait hint, and the wait ends early if the service
+// does not advance its checkpoint within the wait hint, as recommended for service control programs. If the
+// service stalls or ctx expires first, the returned error matches ErrTimeout and wraps a *TimeoutError. A service that
+// is Stopped after the first query while waiting for it to be Running failed to start, the wait ends right away with
+// an error that wraps an *ExitError.
+func (s *Service) WaitForState(ctx context.Context, desired scm.State) error {
+	start := time.Now()
+	lastProgress := start
+	interval := initialPollInterval
+	var history []Observation
+
+	for queries := 0; ; queries++ {
+		status, err := s.Status()
+		if err != nil {
+			return err
+		}
+		if status.State == desired {
+			logrus.Debugf("Service %s entered state %s after %s", s.Name, desired, time.Since(start).Round(time.Millisecond))
+			return nil
+		}
+
+		now := time.Now()
+		if progressed(history, status) {
+			lastProgress = now
+			history = append(history, Observation{State: status.State, CheckPoint: status.CheckPoint, Elapsed: now.Sub(start)})
+			if len(history) == 1 || history[len(history)-2].State != status.State {
+				logrus.Infof("Waiting for service %s to enter state %s, current state: %s", s.Name, desired, status.State)
+			} else {
+				logrus.Debugf("Service %s reached checkpoint %d of state %s, expecting the next within %s", s.Name, status.CheckPoint, status.State, status.WaitHint)
+			}
+		} else if pending(status.State) && status.WaitHint > 0 && now.Sub(lastProgress) > status.WaitHint {
+			return newError(s.Name, "wait for", &TimeoutError{Desired: desired, Stalled: true, History: history})
+		}
+		// the first query may still see the service before it was started
+		if desired == scm.Running && status.State == scm.Stopped && queries > 0 {
+			return newError(s.Name, "wait for", &ExitError{
+				Win32ExitCode:           status.Win32ExitCode,
+				ServiceSpecificExitCode: status.ServiceSpecificExitCode,
+				History:                 history,
+			})
+		}
+
+		interval = nextInterval(interval, status)
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return newError(s.Name, "wait for", &TimeoutError{Desired: desired, History: history})
+			}
+			return newError(s.Name, "wait for", fmt.Errorf("state %s was not reached, current state is %s: %w", desired, status.State, ctx.Err()))
+		case <-time.After(interval):
+		}
+	}
+}
+
+// progressed reports whether the status differs from the last observation in the state or checkpoint.
+func progressed(history []Observation, status scm.Status) bool {
+	if len(history) == 0 {
+		return true
+	}
+	last := history[len(history)-1]
+	return last.State != status.State || last.CheckPoint != status.CheckPoint
+}
+
+func pending(state scm.State) bool {
+	return state == scm.StartPending || state == scm.StopPending || state == scm.ContinuePending || state == scm.PausePending
+}
+
+// nextInterval doubles the interval up to maxPollInterval. A pending service is queried at least every tenth of
+// its wait hint, so that checkpoints are not missed.
+func nextInterval(interval time.Duration, status scm.Status) time.Duration {
+	interval = min(2*interval, maxPollInterval)
+	if pending(status.State) && status.WaitHint > 0 {
+		interval = min(interval, max(status.WaitHint/10, initialPollInterval))
+	}
+	return interval
+}
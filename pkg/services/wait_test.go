@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rancher/wins/pkg/scm"
+)
+
+// stuckService reports the same status for every query.
+type stuckService struct {
+	scm.Service
+	status scm.Status
+}
+
+func (s *stuckService) Query() (scm.Status, error) {
+	return s.status, nil
+}
+
+func TestWaitForStateStalled(t *testing.T) {
+	s := &Service{Name: "kubelet", svc: &stuckService{status: scm.Status{State: scm.StartPending, CheckPoint: 3, WaitHint: 300 * time.Millisecond}}}
+
+	err := s.WaitForState(context.Background(), scm.Running)
+	var timeoutErr *TimeoutError
+	if !errors.Is(err, ErrTimeout) || !errors.As(err, &timeoutErr) {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+	if !timeoutErr.Stalled || len(timeoutErr.History) != 1 || timeoutErr.History[0].CheckPoint != 3 {
+		t.Errorf("expected a stall at checkpoint 3, got %+v", timeoutErr)
+	}
+}
+
+func TestWaitForStateHistory(t *testing.T) {
+	fake := scm.NewFake()
+	fake.PendingQueries = 1000
+	fake.Add("kubelet", scm.Config{}, scm.Stopped)
+	s, err := Open(fake, "kubelet")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer s.Close()
+	if err := s.svc.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	err = s.WaitForState(ctx, scm.Running)
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || timeoutErr.Stalled {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+	if len(timeoutErr.History) < 2 || timeoutErr.History[0].State != scm.StartPending || timeoutErr.History[1].CheckPoint <= timeoutErr.History[0].CheckPoint {
+		t.Errorf("expected the advancing checkpoints to be recorded, got %v", timeoutErr.History)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.WaitForState(cancelled, scm.Running); !errors.Is(err, context.Canceled) || errors.Is(err, ErrTimeout) {
+		t.Errorf("expected a cancellation error, got %v", err)
+	}
+}
+
+func TestWaitForStateFailedStart(t *testing.T) {
+	fake := scm.NewFake()
+	fake.PendingQueries = 1
+	fake.Add("kubelet", scm.Config{}, scm.Stopped)
+	fake.SetFailToStart("kubelet", true)
+	s, err := Open(fake, "kubelet")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	start := time.Now()
+	err = s.Start(ctx)
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected an exit error, got %v", err)
+	}
+	if exitErr.Win32ExitCode != 1 || !strings.Contains(err.Error(), "exit code 1") {
+		t.Errorf("expected the exit code in the error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the wait to end once the service stopped, took %s", elapsed)
+	}
+}
+
+func TestNextInterval(t *testing.T) {
+	testCases := []struct {
+		name     string
+		interval time.Duration
+		status   scm.Status
+		expected time.Duration
+	}{
+		{
+			name:     "doubles",
+			interval: time.Second,
+			status:   scm.Status{State: scm.Stopped},
+			expected: 2 * time.Second,
+		},
+		{
+			name:     "capped",
+			interval: 4 * time.Second,
+			status:   scm.Status{State: scm.Stopped},
+			expected: maxPollInterval,
+		},
+		{
+			name:     "follows the wait hint",
+			interval: 4 * time.Second,
+			status:   scm.Status{State: scm.StartPending, WaitHint: 20 * time.Second},
+			expected: 2 * time.Second,
+		},
+		{
+			name:     "short wait hint",
+			interval: initialPollInterval,
+			status:   scm.Status{State: scm.StopPending, WaitHint: 100 * time.Millisecond},
+			expected: initialPollInterval,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if interval := nextInterval(tc.interval, tc.status); interval != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, interval)
+			}
+		})
+	}
+}
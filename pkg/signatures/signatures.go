@@ -0,0 +1,145 @@
+package signatures
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Suffix is appended to the name of a file to find its detached signature.
+const Suffix = ".sig"
+
+// ErrNoValidSignature is returned when a signature is not valid for any of the trusted keys.
+var ErrNoValidSignature = errors.New("signature does not match any trusted key")
+
+// ParsePublicKeys parses all PEM encoded PKIX public keys in data. Only ed25519 and ECDSA keys are accepted.
+func ParsePublicKeys(data []byte) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "PUBLIC KEY" {
+			continue
+		}
+
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not parse public key")
+		}
+		switch key.(type) {
+		case ed25519.PublicKey, *ecdsa.PublicKey:
+			keys = append(keys, key)
+		default:
+			return nil, fmt.Errorf("unsupported public key type %T, only ed25519 and ECDSA keys are supported", key)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no PEM encoded public key found")
+	}
+	return keys, nil
+}
+
+// LoadPublicKeys reads every public key from the given files.
+func LoadPublicKeys(paths []string) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not read public key file %s", p)
+		}
+		k, err := ParsePublicKeys(data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not load public keys from %s", p)
+		}
+		keys = append(keys, k...)
+	}
+	return keys, nil
+}
+
+// ParsePrivateKey parses a PEM encoded PKCS #8 or SEC 1 private key. Only ed25519 and ECDSA keys are accepted.
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM encoded private key found")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %s", block.Type)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse private key")
+	}
+
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey:
+		return k, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T, only ed25519 and ECDSA keys are supported", key)
+	}
+}
+
+// Sign creates a base64 encoded detached signature of content.
+// ECDSA keys sign the SHA-256 digest of content, ed25519 keys sign content directly.
+func Sign(key crypto.Signer, content []byte) ([]byte, error) {
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, content)
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256(content)
+		sig, err = ecdsa.SignASN1(rand.Reader, k, digest[:])
+	default:
+		err = fmt.Errorf("unsupported private key type %T", key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(base64.StdEncoding.EncodeToString(sig)), nil
+}
+
+// Verify checks that the base64 encoded detached signature of content was created by one of the keys.
+func Verify(keys []crypto.PublicKey, content, signature []byte) error {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return errors.Wrap(err, "could not decode signature")
+	}
+
+	digest := sha256.Sum256(content)
+	for _, key := range keys {
+		switch k := key.(type) {
+		case ed25519.PublicKey:
+			if ed25519.Verify(k, content, sig) {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			if ecdsa.VerifyASN1(k, digest[:], sig) {
+				return nil
+			}
+		}
+	}
+	return ErrNoValidSignature
+}
@@ -0,0 +1,98 @@
+package signatures
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, untrusted, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trusted, err := ParsePublicKeys(append(publicPEM(t, edKey.Public()), publicPEM(t, ecKey.Public())...))
+	if err != nil {
+		t.Fatalf("unexpected error parsing public keys: %v", err)
+	}
+	if len(trusted) != 2 {
+		t.Fatalf("expected 2 trusted keys, got %d", len(trusted))
+	}
+
+	content := []byte(`{"instructions":[]}`)
+
+	type test struct {
+		name        string
+		signer      crypto.Signer
+		content     []byte
+		errExpected bool
+	}
+
+	tests := []test{
+		{name: "ed25519", signer: edKey, content: content},
+		{name: "ECDSA", signer: ecKey, content: content},
+		{name: "Untrusted key", signer: untrusted, content: content, errExpected: true},
+		{name: "Modified content", signer: edKey, content: []byte(`{"instructions":null}`), errExpected: true},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			sig, err := Sign(tst.signer, content)
+			if err != nil {
+				t.Fatalf("unexpected error signing: %v", err)
+			}
+			err = Verify(trusted, tst.content, sig)
+			if err != nil && !tst.errExpected {
+				t.Errorf("unexpected verification error: %v", err)
+			}
+			if err == nil && tst.errExpected {
+				t.Error("expected a verification error")
+			}
+		})
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatalf("unexpected error parsing SEC 1 key: %v", err)
+	}
+	if !ecKey.Equal(signer) {
+		t.Error("parsed key does not match the generated key")
+	}
+
+	if _, err := ParsePrivateKey([]byte("not a key")); err == nil {
+		t.Error("expected an error parsing an invalid key")
+	}
+}
+
+func publicPEM(t *testing.T, key crypto.PublicKey) []byte {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
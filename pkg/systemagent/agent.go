@@ -11,12 +11,14 @@
 	"github.com/rancher/system-agent/pkg/k8splan"
 	"github.com/rancher/system-agent/pkg/localplan"
 	"github.com/rancher/system-agent/pkg/version"
+	"github.com/rancher/wins/pkg/paths"
 	"github.com/sirupsen/logrus"
 )
 
 type Agent struct {
-	cfg           *config.AgentConfig
+	cfg           *Config
 	StrictTLSMode bool
+	status        *statusReporter
 }
 
 func (a *Agent) Run(ctx context.Context) error {
@@ -34,9 +36,14 @@ func (a *Agent) Run(ctx context.Context) error {
 
 	logrus.Infof("Setting %s as the working directory", a.cfg.WorkDir)
 
+	a.status = newStatusReporter(a.cfg.statusFile())
+	a.status.setRemote(RemoteStatus{Mode: RemoteModeDisabled})
+
 	imageUtil := image.NewUtility(a.cfg.ImagesDir, a.cfg.ImageCredentialProviderConfig, a.cfg.ImageCredentialProviderBinDir, a.cfg.AgentRegistriesFile)
 	// Currently we do not support the 'interlockDir' on Windows, as the system-agent install script does not yet utilize those files
 	applier := applyinator.NewApplyinator(a.cfg.WorkDir, a.cfg.PreserveWorkDir, a.cfg.AppliedPlanDir, "", imageUtil)
+	// fallback is only set if a local plan source is enabled while Rancher is unreachable
+	var fallback *offlineFallback
 	if a.cfg.RemoteEnabled {
 		logrus.Infof("Starting remote watch of plans")
 		logrus.Debugf("Agent Strict TLS Mode is %t", a.StrictTLSMode)
@@ -47,18 +54,78 @@ func (a *Agent) Run(ctx context.Context) error {
 			return fmt.Errorf("unable to parse connection info file: %v", err)
 		}
 
-		k8splan.Watch(ctx, *applier, connInfo, a.StrictTLSMode)
+		var plans *planSecretClient
+		if a.cfg.MaintenanceWindows != nil || a.cfg.PlanPolicy != nil || a.cfg.OfflineFallback != nil {
+			var err error
+			if plans, err = newPlanSecretClient(connInfo); err != nil {
+				return err
+			}
+		}
+
+		if a.cfg.OfflineFallback != nil {
+			fallback = &offlineFallback{
+				cfg:    a.cfg.OfflineFallback,
+				plans:  plans,
+				status: a.status,
+			}
+			go fallback.run(ctx)
+		}
+
+		if a.cfg.MaintenanceWindows == nil && a.cfg.PlanPolicy == nil {
+			k8splan.Watch(ctx, *applier, connInfo, a.StrictTLSMode)
+			a.status.setRemote(RemoteStatus{Mode: RemoteModeWatching})
+		} else {
+			if a.cfg.MaintenanceWindows != nil {
+				logrus.Infof("Remote plans will only be applied inside the configured maintenance windows")
+			}
+			if a.cfg.PlanPolicy != nil {
+				logrus.Infof("Remote plans will only be applied if they comply with the plan policy")
+			}
+			gate := &remoteGate{
+				applier: applier,
+				windows: a.cfg.MaintenanceWindows,
+				policy:  a.cfg.PlanPolicy,
+				plans:   plans,
+				status:  a.status,
+			}
+			go gate.run(ctx)
+		}
 	}
 
 	if a.cfg.LocalEnabled {
-		logrus.Infof("Starting local watch of plans in %s", a.cfg.LocalPlanDir)
-		localplan.WatchFiles(ctx, *applier, a.cfg.LocalPlanDir)
+		planDir := a.cfg.LocalPlanDir
+		if len(a.cfg.LocalPlanSources) != 0 {
+			planDir = a.cfg.stagingDir()
+			if a.cfg.plansChecked() {
+				// only wins may write checked plans to the directory the system-agent applies them from
+				if err := paths.RestrictDirectory(planDir); err != nil {
+					return errors.Wrapf(err, "could not restrict access to plan staging directory %s", planDir)
+				}
+			}
+		}
+		// the staging directory is only cleaned up if wins manages it, the localPlanDirectory is otherwise written
+		// to directly
+		if len(a.cfg.LocalPlanSources) != 0 || a.cfg.plansChecked() {
+			if err := unstageRemovedSources(a.cfg.stagingDir(), a.cfg.LocalPlanSources); err != nil {
+				return err
+			}
+		}
+
+		sources, err := newLocalSources(a.cfg, a.status, fallback)
+		if err != nil {
+			return err
+		}
+		for _, source := range sources {
+			go source.run(ctx)
+		}
+		logrus.Infof("Starting local watch of plans in %s", planDir)
+		localplan.WatchFiles(ctx, *applier, planDir)
 	}
 
 	return nil
 }
 
-func New(cfg *config.AgentConfig) *Agent {
+func New(cfg *Config) *Agent {
 	return &Agent{
 		cfg: cfg,
 	}
@@ -0,0 +1,95 @@
+package systemagent
+
+import (
+	"github.com/pkg/errors"
+	"github.com/rancher/system-agent/pkg/config"
+	"github.com/rancher/wins/pkg/defaults"
+	"github.com/rancher/wins/pkg/planpolicy"
+)
+
+// Config is the system-agent section of the wins config. It embeds the upstream
+// system-agent configuration and adds the settings that only wins understands.
+type Config struct {
+	config.AgentConfig
+
+	// StatusFile is where wins records what the embedded system-agent is doing.
+	StatusFile string `yaml:"statusFile" json:"statusFile,omitempty"`
+	// LocalPlanSources are directories of local plans that wins stages into the LocalPlanDir.
+	LocalPlanSources []LocalPlanSource `yaml:"localPlanSources" json:"localPlanSources,omitempty"`
+	// PlanStagingDir replaces the LocalPlanDir as the directory local plans are staged into while they are signed
+	// or checked against a policy. Wins restricts it to the built-in Administrators and LocalSystem.
+	PlanStagingDir string `yaml:"planStagingDirectory" json:"planStagingDirectory,omitempty"`
+	// PlanSigning rejects plans of the LocalPlanSources that are not signed by a trusted key.
+	PlanSigning *PlanSigning `yaml:"planSigning" json:"planSigning,omitempty"`
+	// PlanPolicy blocks local and remote plans whose content is not allowed.
+	PlanPolicy *planpolicy.Policy `yaml:"planPolicy" json:"planPolicy,omitempty"`
+	// MaintenanceWindows restricts when plans received from Rancher are applied.
+	MaintenanceWindows *MaintenanceWindows `yaml:"maintenanceWindows" json:"maintenanceWindows,omitempty"`
+	// OfflineFallback enables one of the LocalPlanSources only while Rancher is unreachable.
+	OfflineFallback *OfflineFallback `yaml:"offlineFallback" json:"offlineFallback,omitempty"`
+}
+
+// Validate ensures that the wins specific settings of the system-agent config are correct.
+func (c *Config) Validate() error {
+	if len(c.LocalPlanSources) != 0 {
+		if err := validateLocalPlanSources(c.LocalPlanDir, c.stagingDir(), c.LocalPlanSources); err != nil {
+			return errors.Wrap(err, "invalid localPlanSources")
+		}
+	}
+	if c.PlanSigning != nil {
+		// without local plan sources the system-agent reads the localPlanDirectory directly
+		if len(c.LocalPlanSources) == 0 {
+			return errors.New("planSigning requires localPlanSources")
+		}
+		if err := c.PlanSigning.validate(); err != nil {
+			return errors.Wrap(err, "invalid planSigning")
+		}
+	}
+	if c.PlanPolicy != nil {
+		if c.LocalEnabled && len(c.LocalPlanSources) == 0 {
+			return errors.New("planPolicy requires localPlanSources when local plans are enabled")
+		}
+		if err := c.PlanPolicy.Validate(); err != nil {
+			return errors.Wrap(err, "invalid planPolicy")
+		}
+	}
+	if c.MaintenanceWindows != nil {
+		if err := c.MaintenanceWindows.validate(); err != nil {
+			return errors.Wrap(err, "invalid maintenanceWindows")
+		}
+	}
+	if c.OfflineFallback != nil {
+		if !c.RemoteEnabled || !c.LocalEnabled {
+			return errors.New("offlineFallback requires both remoteEnabled and localEnabled")
+		}
+		if err := c.OfflineFallback.validate(c.LocalPlanSources); err != nil {
+			return errors.Wrap(err, "invalid offlineFallback")
+		}
+	}
+	return nil
+}
+
+func (c *Config) statusFile() string {
+	if c.StatusFile != "" {
+		return c.StatusFile
+	}
+	return defaults.AgentStatusPath
+}
+
+// plansChecked reports whether local plans are only staged after their signature or content was checked.
+func (c *Config) plansChecked() bool {
+	return c.PlanSigning != nil || c.PlanPolicy != nil
+}
+
+// stagingDir is the directory the system-agent watches for local plans when localPlanSources are configured. Checked
+// plans are staged into a directory that only wins can write, so that plans written to the localPlanDirectory by
+// anyone else are never applied.
+func (c *Config) stagingDir() string {
+	if !c.plansChecked() {
+		return c.LocalPlanDir
+	}
+	if c.PlanStagingDir != "" {
+		return c.PlanStagingDir
+	}
+	return defaults.PlanStagingDir
+}
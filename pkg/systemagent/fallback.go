@@ -0,0 +1,129 @@
+package systemagent
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	offlineFallbackInterval     = 30 * time.Second
+	defaultOfflineFallbackAfter = 30 * time.Minute
+)
+
+// OfflineFallback enables a designated local plan source while Rancher cannot be reached,
+// so that emergency fixes can still be delivered to the node over a side channel.
+type OfflineFallback struct {
+	// Source is the name of the local plan source that is only enabled while Rancher is unreachable.
+	Source string `yaml:"source" json:"source"`
+	// After is how long the API server has to be unreachable before the source is enabled, defaults to 30m.
+	After string `yaml:"after" json:"after,omitempty"`
+
+	after time.Duration
+}
+
+func (f *OfflineFallback) validate(sources []LocalPlanSource) error {
+	f.after = defaultOfflineFallbackAfter
+	if f.After != "" {
+		d, err := time.ParseDuration(f.After)
+		if err != nil {
+			return errors.Wrapf(err, "invalid after %s", f.After)
+		}
+		if d <= 0 {
+			return errors.New("after must be positive")
+		}
+		f.after = d
+	}
+
+	for _, s := range sources {
+		if s.Name == f.Source {
+			return nil
+		}
+	}
+	return fmt.Errorf("source %q is not one of the localPlanSources", f.Source)
+}
+
+// offlineFallback probes the API server that delivers remote plans and engages the fallback
+// once it has been unreachable for longer than the configured period.
+type offlineFallback struct {
+	cfg    *OfflineFallback
+	plans  *planSecretClient
+	status *statusReporter
+
+	engaged atomic.Bool
+	// unreachableSince is when the API server was first found unreachable, zero while it is reachable
+	unreachableSince time.Time
+}
+
+func (f *offlineFallback) run(ctx context.Context) {
+	logrus.Infof("Local plan source %s will be enabled after Rancher is unreachable for %s", f.cfg.Source, f.cfg.after)
+	for {
+		err := f.plans.probe(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		f.update(time.Now(), reachable(err), err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(offlineFallbackInterval):
+		}
+	}
+}
+
+// update records the outcome of a probe at now and engages or disengages the fallback.
+func (f *offlineFallback) update(now time.Time, ok bool, probeErr error) {
+	s := OfflineFallbackStatus{Source: f.cfg.Source}
+
+	if ok {
+		if !f.unreachableSince.IsZero() {
+			logrus.Infof("Rancher is reachable again after %s", now.Sub(f.unreachableSince).Round(time.Second))
+		}
+		f.unreachableSince = time.Time{}
+		if f.engaged.Swap(false) {
+			logrus.Infof("Disengaging offline fallback, disabling local plan source %s", f.cfg.Source)
+		}
+		f.status.setOfflineFallback(s)
+		return
+	}
+
+	if f.unreachableSince.IsZero() {
+		logrus.Warnf("Rancher is unreachable, local plan source %s will be enabled if it stays unreachable for %s: %v", f.cfg.Source, f.cfg.after, probeErr)
+		f.unreachableSince = now
+	}
+	since := f.unreachableSince
+	s.UnreachableSince = &since
+	if probeErr != nil {
+		s.LastError = probeErr.Error()
+	}
+
+	if now.Sub(f.unreachableSince) >= f.cfg.after {
+		s.Engaged = true
+		if !f.engaged.Swap(true) {
+			logrus.Warnf("Rancher has been unreachable since %s, engaging offline fallback and enabling local plan source %s", since.Format(time.RFC3339), f.cfg.Source)
+		}
+	}
+	f.status.setOfflineFallback(s)
+}
+
+// isEngaged reports whether the fallback source should currently be enabled.
+func (f *offlineFallback) isEngaged() bool {
+	return f.engaged.Load()
+}
+
+// reachable reports whether a probe of the plan secret reached the API server. Any response
+// of the API server, including errors such as a missing secret, proves that it is reachable.
+func reachable(err error) bool {
+	if err == nil {
+		return true
+	}
+	var status apierrors.APIStatus
+	return stderrors.As(err, &status)
+}
@@ -0,0 +1,75 @@
+package systemagent
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestOfflineFallbackUpdate(t *testing.T) {
+	cfg := &OfflineFallback{Source: "emergency", After: "10m"}
+	if err := cfg.validate([]LocalPlanSource{{Name: "emergency"}}); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	f := &offlineFallback{cfg: cfg, status: newStatusReporter("")}
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	probeErr := errors.New("dial tcp: connection refused")
+
+	type step struct {
+		offset  time.Duration
+		ok      bool
+		engaged bool
+	}
+
+	steps := []step{
+		{offset: 0, ok: true},
+		{offset: time.Minute, ok: false},
+		{offset: 10 * time.Minute, ok: false},
+		{offset: 11 * time.Minute, ok: false, engaged: true},
+		{offset: 20 * time.Minute, ok: false, engaged: true},
+		{offset: 21 * time.Minute, ok: true},
+		{offset: 22 * time.Minute, ok: false},
+	}
+
+	for _, s := range steps {
+		f.update(start.Add(s.offset), s.ok, probeErr)
+		if f.isEngaged() != s.engaged {
+			t.Errorf("at %s: expected engaged %t, got %t", s.offset, s.engaged, f.isEngaged())
+		}
+		if f.status.status.OfflineFallback.Engaged != s.engaged {
+			t.Errorf("at %s: status does not match the fallback state", s.offset)
+		}
+	}
+}
+
+func TestReachable(t *testing.T) {
+	notFound := apierrors.NewNotFound(schema.GroupResource{Resource: "secrets"}, "plan")
+	if !reachable(pkgerrors.Wrap(notFound, "could not get plan secret")) {
+		t.Error("expected an API server error to count as reachable")
+	}
+	if !reachable(fmt.Errorf("could not read the remote plan: %w", notFound)) {
+		t.Error("expected a wrapped API server error to count as reachable")
+	}
+	if reachable(pkgerrors.Wrap(errors.New("i/o timeout"), "could not get plan secret")) {
+		t.Error("expected a network error to count as unreachable")
+	}
+}
+
+func TestOfflineFallbackValidate(t *testing.T) {
+	sources := []LocalPlanSource{{Name: "emergency"}}
+	invalid := []*OfflineFallback{
+		{Source: "other"},
+		{Source: "emergency", After: "soon"},
+		{Source: "emergency", After: "-1m"},
+	}
+	for _, f := range invalid {
+		if err := f.validate(sources); err == nil {
+			t.Errorf("expected an error validating %+v", f)
+		}
+	}
+}
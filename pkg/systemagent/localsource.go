@@ -0,0 +1,354 @@
+package systemagent
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/system-agent/pkg/applyinator"
+	"github.com/rancher/wins/pkg/planpolicy"
+	"github.com/rancher/wins/pkg/signatures"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	planSuffix = ".plan"
+
+	maxLocalPlanSourcePriority = 999
+	localPlanSourceInterval    = 5 * time.Second
+)
+
+var localPlanSourceName = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// LocalPlanSource is a directory of local plans that wins hands to the embedded system-agent.
+// Plans of all sources are staged into the localPlanDirectory, named so that plans of sources
+// with a higher priority are applied first and plans with the same file name never collide.
+type LocalPlanSource struct {
+	Name      string `yaml:"name" json:"name"`
+	Directory string `yaml:"directory" json:"directory"`
+	// Priority orders the sources, plans of sources with a higher priority are applied first.
+	Priority int `yaml:"priority" json:"priority"`
+	// Enabled defaults to true.
+	Enabled *bool `yaml:"enabled" json:"enabled,omitempty"`
+	// AppliedPlanDirectory is the subdirectory of the appliedPlanDirectory where wins records
+	// the plans it has handed to the system-agent from this source, defaults to the source name.
+	AppliedPlanDirectory string `yaml:"appliedPlanDirectory" json:"appliedPlanDirectory,omitempty"`
+}
+
+func (s *LocalPlanSource) enabled() bool {
+	return s.Enabled == nil || *s.Enabled
+}
+
+func (s *LocalPlanSource) appliedPlanDirectory() string {
+	if s.AppliedPlanDirectory != "" {
+		return s.AppliedPlanDirectory
+	}
+	return s.Name
+}
+
+func validateLocalPlanSources(localPlanDir, stagingDir string, sources []LocalPlanSource) error {
+	if stagingDir == "" {
+		return errors.New("localPlanDirectory must be set when using localPlanSources")
+	}
+
+	names := map[string]bool{}
+	dirs := map[string]bool{filepath.Clean(stagingDir): true}
+	if localPlanDir != "" {
+		dirs[filepath.Clean(localPlanDir)] = true
+	}
+	for _, s := range sources {
+		if !localPlanSourceName.MatchString(s.Name) {
+			return fmt.Errorf("local plan source name %q must consist of lower case alphanumeric characters or '-'", s.Name)
+		}
+		if names[s.Name] {
+			return fmt.Errorf("local plan source name %q is used more than once", s.Name)
+		}
+		names[s.Name] = true
+
+		if s.Directory == "" {
+			return fmt.Errorf("local plan source %s must have a directory", s.Name)
+		}
+		if dirs[filepath.Clean(s.Directory)] {
+			return fmt.Errorf("directory %s of local plan source %s is already used by another source, the localPlanDirectory or the planStagingDirectory", s.Directory, s.Name)
+		}
+		dirs[filepath.Clean(s.Directory)] = true
+
+		if s.Priority < 0 || s.Priority > maxLocalPlanSourcePriority {
+			return fmt.Errorf("priority of local plan source %s must be between 0 and %d", s.Name, maxLocalPlanSourcePriority)
+		}
+		if filepath.IsAbs(s.AppliedPlanDirectory) || strings.Contains(s.AppliedPlanDirectory, "..") {
+			return fmt.Errorf("appliedPlanDirectory of local plan source %s must be a relative subdirectory", s.Name)
+		}
+	}
+	return nil
+}
+
+// PlanSigning requires every local plan to have a detached signature, created by one of the
+// trusted keys, in a file next to it with the same name and a .sig suffix.
+type PlanSigning struct {
+	// TrustedKeyFiles are PEM files containing ed25519 or ECDSA public keys.
+	TrustedKeyFiles []string `yaml:"trustedKeyFiles" json:"trustedKeyFiles"`
+}
+
+func (p *PlanSigning) validate() error {
+	if len(p.TrustedKeyFiles) == 0 {
+		return errors.New("at least one trusted key file must be provided")
+	}
+	return nil
+}
+
+// localSource stages the plans of a LocalPlanSource into the staging directory watched by the system-agent.
+type localSource struct {
+	cfg        LocalPlanSource
+	stagingDir string
+	appliedDir string
+	status     *statusReporter
+	// trustedKeys is nil if plans do not have to be signed
+	trustedKeys []crypto.PublicKey
+	policy      *planpolicy.Policy
+	// fallback is set for the offline fallback source, which is only enabled while the fallback is engaged
+	fallback *offlineFallback
+	// rejected holds the last rejection reason of each plan, so that a rejection is only logged once
+	rejected map[string]string
+}
+
+func newLocalSources(cfg *Config, status *statusReporter, fallback *offlineFallback) ([]*localSource, error) {
+	var trustedKeys []crypto.PublicKey
+	if cfg.PlanSigning != nil {
+		keys, err := signatures.LoadPublicKeys(cfg.PlanSigning.TrustedKeyFiles)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not load trusted keys for plan signing")
+		}
+		trustedKeys = keys
+	}
+
+	var sources []*localSource
+	for _, s := range cfg.LocalPlanSources {
+		source := &localSource{
+			cfg:         s,
+			stagingDir:  cfg.stagingDir(),
+			appliedDir:  filepath.Join(cfg.AppliedPlanDir, s.appliedPlanDirectory()),
+			status:      status,
+			trustedKeys: trustedKeys,
+			policy:      cfg.PlanPolicy,
+			rejected:    map[string]string{},
+		}
+		if fallback != nil && fallback.cfg.Source == s.Name {
+			source.fallback = fallback
+		}
+		sources = append(sources, source)
+	}
+	sort.SliceStable(sources, func(i, j int) bool {
+		return sources[i].cfg.Priority > sources[j].cfg.Priority
+	})
+	return sources, nil
+}
+
+func (s *localSource) run(ctx context.Context) {
+	logrus.Infof("Starting watch of local plan source %s in %s with priority %d", s.cfg.Name, s.cfg.Directory, s.cfg.Priority)
+	for {
+		if err := s.sync(); err != nil {
+			logrus.Errorf("Error staging plans of local plan source %s: %v", s.cfg.Name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(localPlanSourceInterval):
+		}
+	}
+}
+
+func (s *localSource) enabled() bool {
+	if s.fallback != nil {
+		return s.cfg.enabled() && s.fallback.isEngaged()
+	}
+	return s.cfg.enabled()
+}
+
+// sync makes the staged plans of the source match the plans in its directory.
+func (s *localSource) sync() error {
+	st := LocalSourceStatus{
+		Name:     s.cfg.Name,
+		Enabled:  s.enabled(),
+		Priority: s.cfg.Priority,
+	}
+	defer func() {
+		s.status.setLocalSource(st)
+	}()
+
+	if err := os.MkdirAll(s.stagingDir, os.ModePerm); err != nil {
+		return errors.Wrapf(err, "could not create %s", s.stagingDir)
+	}
+
+	wanted := map[string]bool{}
+	if s.enabled() {
+		entries, err := os.ReadDir(s.cfg.Directory)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "could not read %s", s.cfg.Directory)
+		}
+
+		for _, e := range entries {
+			if e.IsDir() || !strings.HasSuffix(e.Name(), planSuffix) {
+				continue
+			}
+
+			staged := s.stagedName(e.Name())
+			wanted[staged] = true
+			if err := s.stage(e.Name(), staged); err != nil {
+				if s.rejected[e.Name()] != err.Error() {
+					logrus.Errorf("Rejected plan %s of local plan source %s: %v", e.Name(), s.cfg.Name, err)
+					s.rejected[e.Name()] = err.Error()
+				}
+				st.Rejected = append(st.Rejected, RejectedPlan{File: e.Name(), Reason: err.Error()})
+				delete(wanted, staged)
+				continue
+			}
+			delete(s.rejected, e.Name())
+			st.Staged = append(st.Staged, e.Name())
+		}
+	}
+
+	// remove plans that were deleted from the source, or staged under a previous priority
+	entries, err := os.ReadDir(s.stagingDir)
+	if err != nil {
+		return errors.Wrapf(err, "could not read %s", s.stagingDir)
+	}
+	for _, e := range entries {
+		if !stagedBy(e.Name(), s.cfg.Name) || wanted[e.Name()] {
+			continue
+		}
+		logrus.Infof("Removing staged plan %s of local plan source %s", e.Name(), s.cfg.Name)
+		if err := os.Remove(filepath.Join(s.stagingDir, e.Name())); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "could not remove staged plan %s", e.Name())
+		}
+	}
+	return nil
+}
+
+// stage copies a plan into the staging directory if its content changed.
+func (s *localSource) stage(file, staged string) error {
+	content, err := os.ReadFile(filepath.Join(s.cfg.Directory, file))
+	if err != nil {
+		return errors.Wrap(err, "could not read plan")
+	}
+
+	if err := s.verify(file, content); err != nil {
+		return err
+	}
+
+	// the policy is checked before the staged copy is compared, so that plans staged under a previous policy are
+	// removed by sync if they violate the current one
+	cp, err := applyinator.CalculatePlan(content)
+	if err != nil {
+		return errors.Wrap(err, "could not parse plan")
+	}
+	if s.policy != nil {
+		if violations := s.policy.Evaluate(cp.Plan); len(violations) > 0 {
+			var msgs []string
+			for _, v := range violations {
+				msgs = append(msgs, v.String())
+			}
+			return fmt.Errorf("plan violates the plan policy: %s", strings.Join(msgs, "; "))
+		}
+	}
+
+	stagedPath := filepath.Join(s.stagingDir, staged)
+	if existing, err := os.ReadFile(stagedPath); err == nil && bytes.Equal(existing, content) {
+		return nil
+	}
+
+	if err := writeFileAtomic(stagedPath, content); err != nil {
+		return errors.Wrap(err, "could not stage plan")
+	}
+	logrus.Infof("Staged plan %s of local plan source %s as %s", file, s.cfg.Name, staged)
+
+	if err := os.MkdirAll(s.appliedDir, os.ModePerm); err != nil {
+		return errors.Wrapf(err, "could not create %s", s.appliedDir)
+	}
+	return writeFileAtomic(filepath.Join(s.appliedDir, file), content)
+}
+
+// verify checks the detached signature of a plan if plan signing is enabled.
+func (s *localSource) verify(file string, content []byte) error {
+	if s.trustedKeys == nil {
+		return nil
+	}
+
+	sig, err := os.ReadFile(filepath.Join(s.cfg.Directory, file+signatures.Suffix))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.New("plan is not signed")
+		}
+		return errors.Wrap(err, "could not read plan signature")
+	}
+	if err := signatures.Verify(s.trustedKeys, content, sig); err != nil {
+		return errors.Wrap(err, "invalid plan signature")
+	}
+	return nil
+}
+
+// stagedName prefixes the plan with the inverted priority and the source name, so that
+// the lexical order in which the system-agent applies plans follows the source priority.
+func (s *localSource) stagedName(file string) string {
+	return fmt.Sprintf("%03d_%s_%s", maxLocalPlanSourcePriority-s.cfg.Priority, s.cfg.Name, file)
+}
+
+// stagedBy reports whether the staged file name is one of the plans of the named source.
+func stagedBy(staged, source string) bool {
+	return stagingSource(staged) == source
+}
+
+// stagingSource returns the name of the source that staged the file, or an empty string if the file was not staged.
+func stagingSource(staged string) string {
+	parts := strings.SplitN(staged, "_", 3)
+	if len(parts) != 3 || len(parts[0]) != 3 || !strings.HasSuffix(staged, planSuffix) {
+		return ""
+	}
+	return parts[1]
+}
+
+// unstageRemovedSources removes the plans staged by sources that are not configured anymore, as no source
+// removes them otherwise and the system-agent would keep applying them. Plans of disabled sources are removed
+// by the sources themselves.
+func unstageRemovedSources(stagingDir string, sources []LocalPlanSource) error {
+	configured := map[string]bool{}
+	for _, s := range sources {
+		configured[s.Name] = true
+	}
+
+	entries, err := os.ReadDir(stagingDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "could not read %s", stagingDir)
+	}
+	for _, e := range entries {
+		source := stagingSource(e.Name())
+		if e.IsDir() || source == "" || configured[source] {
+			continue
+		}
+		logrus.Infof("Removing staged plan %s of local plan source %s, which is not configured anymore", e.Name(), source)
+		if err := os.Remove(filepath.Join(stagingDir, e.Name())); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "could not remove staged plan %s", e.Name())
+		}
+	}
+	return nil
+}
+
+func writeFileAtomic(path string, content []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, os.ModePerm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
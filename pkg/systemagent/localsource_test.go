@@ -0,0 +1,279 @@
+package systemagent
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rancher/wins/pkg/planpolicy"
+	"github.com/rancher/wins/pkg/signatures"
+)
+
+func TestLocalSourceSync(t *testing.T) {
+	root := t.TempDir()
+	cfg := &Config{}
+	cfg.LocalPlanDir = filepath.Join(root, "staging")
+	cfg.AppliedPlanDir = filepath.Join(root, "applied")
+	disabled := false
+	cfg.LocalPlanSources = []LocalPlanSource{
+		{Name: "baseline", Directory: filepath.Join(root, "baseline"), Priority: 10},
+		{Name: "emergency", Directory: filepath.Join(root, "emergency"), Priority: 900},
+		{Name: "team", Directory: filepath.Join(root, "team"), Priority: 500, Enabled: &disabled},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+
+	plan := []byte(`{"instructions":[{"name":"test","command":"powershell.exe"}]}`)
+	for _, s := range cfg.LocalPlanSources {
+		if err := os.MkdirAll(s.Directory, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(s.Directory, "node.plan"), plan, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(cfg.LocalPlanSources[0].Directory, "broken.plan"), []byte("{"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := newLocalSources(cfg, newStatusReporter(""), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range sources {
+		if err := s.sync(); err != nil {
+			t.Fatalf("unexpected error syncing %s: %v", s.cfg.Name, err)
+		}
+	}
+
+	expected := []string{"099_emergency_node.plan", "989_baseline_node.plan"}
+	if staged := listDir(t, cfg.LocalPlanDir); !reflect.DeepEqual(staged, expected) {
+		t.Errorf("expected staged plans %v, got %v", expected, staged)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.AppliedPlanDir, "emergency", "node.plan")); err != nil {
+		t.Errorf("expected the staged plan to be recorded in the applied plan subdirectory: %v", err)
+	}
+
+	// deleting a plan from its source removes the staged copy
+	if err := os.Remove(filepath.Join(cfg.LocalPlanSources[1].Directory, "node.plan")); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range sources {
+		if err := s.sync(); err != nil {
+			t.Fatalf("unexpected error syncing %s: %v", s.cfg.Name, err)
+		}
+	}
+
+	expected = []string{"989_baseline_node.plan"}
+	if staged := listDir(t, cfg.LocalPlanDir); !reflect.DeepEqual(staged, expected) {
+		t.Errorf("expected staged plans %v, got %v", expected, staged)
+	}
+
+	// checked plans are staged into the plan staging directory instead, and removed once they violate the policy
+	cfg.PlanStagingDir = filepath.Join(root, "checked")
+	for _, command := range []string{"powershell.exe", "cmd.exe"} {
+		cfg.PlanPolicy = &planpolicy.Policy{AllowedCommands: []planpolicy.AllowedCommand{{Command: command}}}
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("unexpected validation error: %v", err)
+		}
+		if sources, err = newLocalSources(cfg, newStatusReporter(""), nil); err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range sources {
+			if err := s.sync(); err != nil {
+				t.Fatalf("unexpected error syncing %s: %v", s.cfg.Name, err)
+			}
+		}
+		if command == "powershell.exe" {
+			if staged := listDir(t, cfg.PlanStagingDir); !reflect.DeepEqual(staged, expected) {
+				t.Errorf("expected checked plans %v, got %v", expected, staged)
+			}
+		}
+	}
+	if staged := listDir(t, cfg.PlanStagingDir); len(staged) != 0 {
+		t.Errorf("expected the staged plans to be removed, got %v", staged)
+	}
+	if staged := listDir(t, cfg.LocalPlanDir); !reflect.DeepEqual(staged, expected) {
+		t.Errorf("expected the localPlanDirectory to be left alone, got %v", staged)
+	}
+}
+
+func TestUnstageRemovedSources(t *testing.T) {
+	root := t.TempDir()
+	cfg := &Config{}
+	cfg.LocalPlanDir = filepath.Join(root, "staging")
+	cfg.LocalPlanSources = []LocalPlanSource{
+		{Name: "baseline", Directory: filepath.Join(root, "baseline"), Priority: 10},
+		{Name: "team", Directory: filepath.Join(root, "team"), Priority: 500},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+
+	for _, s := range cfg.LocalPlanSources {
+		if err := os.MkdirAll(s.Directory, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(s.Directory, "node.plan"), []byte(`{"instructions":[]}`), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sources, err := newLocalSources(cfg, newStatusReporter(""), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range sources {
+		if err := s.sync(); err != nil {
+			t.Fatalf("unexpected error syncing %s: %v", s.cfg.Name, err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(cfg.LocalPlanDir, "notes.txt"), nil, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	// the team source is removed from the config
+	cfg.LocalPlanSources = cfg.LocalPlanSources[:1]
+	if err := unstageRemovedSources(cfg.stagingDir(), cfg.LocalPlanSources); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"989_baseline_node.plan", "notes.txt"}
+	if staged := listDir(t, cfg.LocalPlanDir); !reflect.DeepEqual(staged, expected) {
+		t.Errorf("expected staged plans %v, got %v", expected, staged)
+	}
+}
+
+func TestValidateLocalPlanSources(t *testing.T) {
+	type test struct {
+		name    string
+		sources []LocalPlanSource
+	}
+
+	tests := []test{
+		{
+			name:    "Duplicate names",
+			sources: []LocalPlanSource{{Name: "a", Directory: "c:/a"}, {Name: "a", Directory: "c:/b"}},
+		},
+		{
+			name:    "Invalid name",
+			sources: []LocalPlanSource{{Name: "Team_A", Directory: "c:/a"}},
+		},
+		{
+			name:    "Shared directory",
+			sources: []LocalPlanSource{{Name: "a", Directory: "c:/a"}, {Name: "b", Directory: "c:/a/"}},
+		},
+		{
+			name:    "Directory is the staging directory",
+			sources: []LocalPlanSource{{Name: "a", Directory: "c:/plans"}},
+		},
+		{
+			name:    "Priority out of range",
+			sources: []LocalPlanSource{{Name: "a", Directory: "c:/a", Priority: 1000}},
+		},
+		{
+			name:    "Applied plan directory escapes",
+			sources: []LocalPlanSource{{Name: "a", Directory: "c:/a", AppliedPlanDirectory: "../a"}},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			if err := validateLocalPlanSources("c:/plans", "c:/plans", tst.sources); err == nil {
+				t.Error("expected a validation error")
+			}
+		})
+	}
+}
+
+func listDir(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestLocalSourceSignedPlans(t *testing.T) {
+	root := t.TempDir()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(root, "trusted.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{}
+	cfg.LocalPlanDir = filepath.Join(root, "staging")
+	cfg.AppliedPlanDir = filepath.Join(root, "applied")
+	cfg.PlanStagingDir = filepath.Join(root, "checked")
+	cfg.LocalPlanSources = []LocalPlanSource{{Name: "signed", Directory: filepath.Join(root, "signed")}}
+	cfg.PlanSigning = &PlanSigning{TrustedKeyFiles: []string{keyFile}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+
+	dir := cfg.LocalPlanSources[0].Directory
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	plan := []byte(`{"instructions":[]}`)
+	sig, err := signatures.Sign(key, plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string][]byte{
+		"signed.plan":       plan,
+		"signed.plan.sig":   sig,
+		"unsigned.plan":     plan,
+		"tampered.plan":     []byte(`{"instructions":null}`),
+		"tampered.plan.sig": sig,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), content, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// plans that are dropped into the localPlanDirectory are neither staged nor removed
+	if err := os.MkdirAll(cfg.LocalPlanDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(cfg.LocalPlanDir, "evil.plan"), plan, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	status := newStatusReporter("")
+	sources, err := newLocalSources(cfg, status, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sources[0].sync(); err != nil {
+		t.Fatalf("unexpected error syncing: %v", err)
+	}
+
+	expected := []string{"999_signed_signed.plan"}
+	if staged := listDir(t, cfg.PlanStagingDir); !reflect.DeepEqual(staged, expected) {
+		t.Errorf("expected staged plans %v, got %v", expected, staged)
+	}
+	if unchecked := listDir(t, cfg.LocalPlanDir); !reflect.DeepEqual(unchecked, []string{"evil.plan"}) {
+		t.Errorf("expected the localPlanDirectory to be left alone, got %v", unchecked)
+	}
+	if rejected := status.status.LocalSources[0].Rejected; len(rejected) != 2 {
+		t.Errorf("expected 2 rejected plans, got %v", rejected)
+	}
+}
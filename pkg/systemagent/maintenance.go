@@ -0,0 +1,237 @@
+package systemagent
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	// Windows hosts do not ship the IANA time zone database, so it has to be embedded
+	// for the maintenance window time zone to be loadable.
+	_ "time/tzdata"
+
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+)
+
+// MaintenanceWindows restricts when plans received from Rancher may be applied to the node.
+// Outside every window, remote plans are queued and only applied once the next window opens,
+// unless an urgent override is present.
+type MaintenanceWindows struct {
+	// Timezone is an IANA time zone name, the windows are evaluated in the node's local time if empty.
+	Timezone string `yaml:"timezone" json:"timezone,omitempty"`
+	// OverrideFile is a local flag, while the file exists remote plans are applied regardless of the windows.
+	OverrideFile string              `yaml:"overrideFile" json:"overrideFile,omitempty"`
+	Windows      []MaintenanceWindow `yaml:"windows" json:"windows"`
+
+	location *time.Location
+}
+
+// MaintenanceWindow is either a cron schedule that opens the window for a duration,
+// or a daily time range limited to certain days of the week.
+type MaintenanceWindow struct {
+	// Schedule is a standard 5 field cron expression marking the start of the window.
+	Schedule string `yaml:"schedule" json:"schedule,omitempty"`
+	// Duration is how long the window stays open after Schedule fires, e.g. 4h.
+	Duration string `yaml:"duration" json:"duration,omitempty"`
+	// Days lists the days of the week the time range applies to, every day if empty.
+	Days []string `yaml:"days" json:"days,omitempty"`
+	// Start and End are HH:MM times of day. A range where End is not after Start ends on the following day.
+	Start string `yaml:"start" json:"start,omitempty"`
+	End   string `yaml:"end" json:"end,omitempty"`
+
+	schedule cron.Schedule
+	duration time.Duration
+	days     map[time.Weekday]bool
+	start    clock
+	end      clock
+}
+
+type clock struct {
+	hour   int
+	minute int
+}
+
+func (m *MaintenanceWindows) validate() error {
+	if len(m.Windows) == 0 {
+		return errors.New("at least one window must be provided")
+	}
+
+	m.location = time.Local
+	if m.Timezone != "" {
+		loc, err := time.LoadLocation(m.Timezone)
+		if err != nil {
+			return errors.Wrapf(err, "could not load timezone %s", m.Timezone)
+		}
+		m.location = loc
+	}
+
+	for i := range m.Windows {
+		if err := m.Windows[i].validate(); err != nil {
+			return errors.Wrapf(err, "window %d", i)
+		}
+	}
+	return nil
+}
+
+// loc returns the location the windows are evaluated in, the node's local time if they were not validated.
+func (m *MaintenanceWindows) loc() *time.Location {
+	if m.location == nil {
+		return time.Local
+	}
+	return m.location
+}
+
+// Open reports whether t falls inside any of the maintenance windows.
+func (m *MaintenanceWindows) Open(t time.Time) bool {
+	t = t.In(m.loc())
+	for i := range m.Windows {
+		if m.Windows[i].contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextOpen returns the start of the first window opening after t.
+// The zero time is returned if no window opens within the next week.
+func (m *MaintenanceWindows) NextOpen(t time.Time) time.Time {
+	t = t.In(m.loc())
+	var next time.Time
+	for i := range m.Windows {
+		n := m.Windows[i].next(t)
+		if n.IsZero() {
+			continue
+		}
+		if next.IsZero() || n.Before(next) {
+			next = n
+		}
+	}
+	return next
+}
+
+func (w *MaintenanceWindow) validate() error {
+	if w.Schedule != "" {
+		if w.Start != "" || w.End != "" || len(w.Days) != 0 {
+			return errors.New("schedule cannot be combined with days, start or end")
+		}
+		s, err := cron.ParseStandard(w.Schedule)
+		if err != nil {
+			return errors.Wrapf(err, "could not parse schedule %q", w.Schedule)
+		}
+		d, err := time.ParseDuration(w.Duration)
+		if err != nil {
+			return errors.Wrapf(err, "could not parse duration %q", w.Duration)
+		}
+		if d <= 0 {
+			return errors.New("duration must be positive")
+		}
+		w.schedule = s
+		w.duration = d
+		return nil
+	}
+
+	if w.Duration != "" {
+		return errors.New("duration can only be used with schedule")
+	}
+
+	var err error
+	if w.start, err = parseClock(w.Start); err != nil {
+		return errors.Wrap(err, "invalid start")
+	}
+	if w.start.hour == 24 {
+		return errors.New("start cannot be 24:00")
+	}
+	if w.end, err = parseClock(w.End); err != nil {
+		return errors.Wrap(err, "invalid end")
+	}
+	if w.start == w.end {
+		return errors.New("start and end cannot be the same time")
+	}
+
+	w.days = make(map[time.Weekday]bool, len(w.Days))
+	for _, d := range w.Days {
+		day, err := parseWeekday(d)
+		if err != nil {
+			return err
+		}
+		w.days[day] = true
+	}
+	return nil
+}
+
+func (w *MaintenanceWindow) contains(t time.Time) bool {
+	if w.schedule != nil {
+		// the most recent window start is within the duration if the next start after (t - duration) is not after t
+		return !w.schedule.Next(t.Add(-w.duration)).After(t)
+	}
+
+	// a range ending on the following day may have started yesterday
+	for _, offset := range []int{0, -1} {
+		start, end := w.rangeOn(t, offset)
+		if w.onDay(start.Weekday()) && !t.Before(start) && t.Before(end) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *MaintenanceWindow) next(t time.Time) time.Time {
+	if w.schedule != nil {
+		return w.schedule.Next(t)
+	}
+
+	for offset := 0; offset <= 7; offset++ {
+		start, _ := w.rangeOn(t, offset)
+		if w.onDay(start.Weekday()) && start.After(t) {
+			return start
+		}
+	}
+	return time.Time{}
+}
+
+// rangeOn returns the start and end of the time range on the day offset days away from t.
+func (w *MaintenanceWindow) rangeOn(t time.Time, offset int) (time.Time, time.Time) {
+	y, m, d := t.Date()
+	start := time.Date(y, m, d+offset, w.start.hour, w.start.minute, 0, 0, t.Location())
+	end := time.Date(y, m, d+offset, w.end.hour, w.end.minute, 0, 0, t.Location())
+	if !end.After(start) {
+		end = time.Date(y, m, d+offset+1, w.end.hour, w.end.minute, 0, 0, t.Location())
+	}
+	return start, end
+}
+
+func (w *MaintenanceWindow) onDay(day time.Weekday) bool {
+	return len(w.days) == 0 || w.days[day]
+}
+
+// parseClock parses a HH:MM time of day, 24:00 is accepted as the end of the day.
+func parseClock(s string) (clock, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 2 {
+		return clock{}, fmt.Errorf("%q is not in HH:MM format", s)
+	}
+	h, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return clock{}, fmt.Errorf("%q is not in HH:MM format", s)
+	}
+	m, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return clock{}, fmt.Errorf("%q is not in HH:MM format", s)
+	}
+	if h < 0 || h > 24 || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return clock{}, fmt.Errorf("%q is not a valid time of day", s)
+	}
+	return clock{hour: h, minute: m}, nil
+}
+
+func parseWeekday(s string) (time.Weekday, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if s == name || s == name[:3] {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("%q is not a day of the week", s)
+}
@@ -0,0 +1,135 @@
+package systemagent
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaintenanceWindowsOpen(t *testing.T) {
+	type test struct {
+		name     string
+		window   MaintenanceWindow
+		at       string
+		expected bool
+	}
+
+	tests := []test{
+		{
+			name:     "Inside daily range",
+			window:   MaintenanceWindow{Start: "01:00", End: "05:00"},
+			at:       "2026-10-20T03:00:00Z",
+			expected: true,
+		},
+		{
+			name:     "End of daily range is exclusive",
+			window:   MaintenanceWindow{Start: "01:00", End: "05:00"},
+			at:       "2026-10-20T05:00:00Z",
+			expected: false,
+		},
+		{
+			name:     "Range spanning midnight, after midnight",
+			window:   MaintenanceWindow{Days: []string{"Monday"}, Start: "22:00", End: "02:00"},
+			at:       "2026-10-20T01:30:00Z", // Tuesday
+			expected: true,
+		},
+		{
+			name:     "Range spanning midnight, wrong start day",
+			window:   MaintenanceWindow{Days: []string{"Tue"}, Start: "22:00", End: "02:00"},
+			at:       "2026-10-20T01:30:00Z", // Tuesday, window started on Monday
+			expected: false,
+		},
+		{
+			name:     "Weekend only on a weekday",
+			window:   MaintenanceWindow{Days: []string{"saturday", "sunday"}, Start: "00:00", End: "24:00"},
+			at:       "2026-10-21T12:00:00Z", // Wednesday
+			expected: false,
+		},
+		{
+			name:     "Cron schedule inside duration",
+			window:   MaintenanceWindow{Schedule: "0 22 * * 1-5", Duration: "4h"},
+			at:       "2026-10-20T23:59:00Z",
+			expected: true,
+		},
+		{
+			name:     "Cron schedule after duration",
+			window:   MaintenanceWindow{Schedule: "0 22 * * 1-5", Duration: "4h"},
+			at:       "2026-10-21T02:00:00Z",
+			expected: false,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			m := &MaintenanceWindows{Timezone: "UTC", Windows: []MaintenanceWindow{tst.window}}
+			if err := m.validate(); err != nil {
+				t.Fatalf("unexpected validation error: %v", err)
+			}
+			at, err := time.Parse(time.RFC3339, tst.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if open := m.Open(at); open != tst.expected {
+				t.Errorf("expected open to be %t at %s, got %t", tst.expected, tst.at, open)
+			}
+		})
+	}
+}
+
+func TestMaintenanceWindowsNextOpen(t *testing.T) {
+	m := &MaintenanceWindows{
+		Timezone: "America/New_York",
+		Windows: []MaintenanceWindow{
+			{Days: []string{"Sat"}, Start: "02:00", End: "06:00"},
+			{Schedule: "30 23 * * 3", Duration: "1h"},
+		},
+	}
+	if err := m.validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+
+	// Tuesday 12:00 in New York
+	at := time.Date(2026, time.October, 20, 16, 0, 0, 0, time.UTC)
+	expected := time.Date(2026, time.October, 21, 23, 30, 0, 0, m.location)
+	if next := m.NextOpen(at); !next.Equal(expected) {
+		t.Errorf("expected next window at %s, got %s", expected, next)
+	}
+}
+
+func TestMaintenanceWindowsWithoutLocation(t *testing.T) {
+	m := &MaintenanceWindows{Windows: []MaintenanceWindow{{Start: "00:00", End: "24:00"}}}
+	if err := m.Windows[0].validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+
+	// windows that were not validated as a whole are evaluated in the node's local time
+	at := time.Date(2026, time.October, 20, 12, 0, 0, 0, time.UTC)
+	if !m.Open(at) {
+		t.Error("expected a window spanning the whole day to be open")
+	}
+	if next := m.NextOpen(at); next.Location() != time.Local {
+		t.Errorf("expected the next window in local time, got %s", next.Location())
+	}
+}
+
+func TestMaintenanceWindowsValidate(t *testing.T) {
+	invalid := []MaintenanceWindow{
+		{Schedule: "0 22 * * *"},
+		{Schedule: "not a schedule", Duration: "1h"},
+		{Schedule: "0 22 * * *", Duration: "1h", Start: "01:00"},
+		{Start: "25:00", End: "02:00"},
+		{Start: "01:00", End: "01:00"},
+		{Start: "01:00", End: "02:00", Days: []string{"Someday"}},
+		{Start: "01:00", End: "02:00", Duration: "1h"},
+	}
+
+	for _, w := range invalid {
+		m := &MaintenanceWindows{Windows: []MaintenanceWindow{w}}
+		if err := m.validate(); err == nil {
+			t.Errorf("expected an error validating %+v", w)
+		}
+	}
+
+	if err := (&MaintenanceWindows{}).validate(); err == nil {
+		t.Error("expected an error validating maintenance windows without any window")
+	}
+}
@@ -0,0 +1,475 @@
+package systemagent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/system-agent/pkg/applyinator"
+	"github.com/rancher/system-agent/pkg/config"
+	"github.com/rancher/system-agent/pkg/prober"
+	"github.com/rancher/wins/pkg/planpolicy"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// these keys match the ones used by the system-agent k8splan watcher
+	planSecretPlanKey                  = "plan"
+	planSecretAppliedChecksumKey       = "applied-checksum"
+	planSecretAppliedOutputKey         = "applied-output"
+	planSecretAppliedPeriodicOutputKey = "applied-periodic-output"
+	planSecretFailedChecksumKey        = "failed-checksum"
+	planSecretFailedOutputKey          = "failed-output"
+	planSecretFailureCountKey          = "failure-count"
+	planSecretMaxFailuresKey           = "max-failures"
+	planSecretProbeStatusesKey         = "probe-statuses"
+
+	// UrgentPlanAnnotation can be set to "true" on the plan secret to apply a plan outside of the maintenance windows.
+	UrgentPlanAnnotation = "wins.cattle.io/urgent-plan"
+
+	// remoteGateInterval is how often the applied plan runs its periodic instructions and probes, like the
+	// system-agent plan watcher does
+	remoteGateInterval = 5 * time.Second
+	// remotePlanRetryCooldown is how long a plan that failed to apply is not applied again
+	remotePlanRetryCooldown = 30 * time.Second
+)
+
+// remotePlan is the state of the plan secret that Rancher maintains for this node.
+type remotePlan struct {
+	checksum        string
+	appliedChecksum string
+	urgent          bool
+	plan            applyinator.Plan
+}
+
+// pending reports whether Rancher has delivered a plan that has not been applied yet.
+func (p remotePlan) pending() bool {
+	return p.checksum != "" && p.checksum != p.appliedChecksum
+}
+
+// planSecretClient reads the plan secret that Rancher maintains for this node, and records the outcome of the plans
+// that wins applies from it.
+type planSecretClient struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+}
+
+func newPlanSecretClient(connInfo config.ConnectionInfo) (*planSecretClient, error) {
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig([]byte(connInfo.KubeConfig))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse kubeconfig from connection info")
+	}
+
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create kubernetes client")
+	}
+
+	return &planSecretClient{
+		client:    client,
+		namespace: connInfo.Namespace,
+		name:      connInfo.SecretName,
+	}, nil
+}
+
+// probe reads the plan secret without inspecting it, to find out whether the API server is reachable.
+func (r *planSecretClient) probe(ctx context.Context) error {
+	_, err := r.client.CoreV1().Secrets(r.namespace).Get(ctx, r.name, metav1.GetOptions{})
+	return errors.Wrapf(err, "could not get plan secret %s/%s", r.namespace, r.name)
+}
+
+func (r *planSecretClient) get(ctx context.Context) (*corev1.Secret, error) {
+	secret, err := r.client.CoreV1().Secrets(r.namespace).Get(ctx, r.name, metav1.GetOptions{})
+	return secret, errors.Wrapf(err, "could not get plan secret %s/%s", r.namespace, r.name)
+}
+
+func (r *planSecretClient) read(ctx context.Context) (remotePlan, error) {
+	secret, err := r.get(ctx)
+	if err != nil {
+		return remotePlan{}, err
+	}
+	return parsePlanSecret(secret)
+}
+
+// record sets the data of the plan secret and removes the keys, retrying if Rancher updated the secret in the meantime.
+// The secret is only updated if its data changes.
+func (r *planSecretClient) record(ctx context.Context, data map[string][]byte, remove []string) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		secret, err := r.get(ctx)
+		if err != nil {
+			return err
+		}
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+
+		changed := false
+		for k, v := range data {
+			if existing, ok := secret.Data[k]; !ok || !bytes.Equal(existing, v) {
+				secret.Data[k] = v
+				changed = true
+			}
+		}
+		for _, k := range remove {
+			if _, ok := secret.Data[k]; ok {
+				delete(secret.Data, k)
+				changed = true
+			}
+		}
+		if !changed {
+			return nil
+		}
+		_, err = r.client.CoreV1().Secrets(r.namespace).Update(ctx, secret, metav1.UpdateOptions{})
+		return err
+	})
+	return errors.Wrapf(err, "could not update plan secret %s/%s", r.namespace, r.name)
+}
+
+// follow calls accept with the plan of the secret whenever the secret changes, starting with its current plan, until
+// accept returns false or ctx is done.
+func (r *planSecretClient) follow(ctx context.Context, accept func(remotePlan) bool) error {
+	for ctx.Err() == nil {
+		// the API server closes watches after a while, they are established again
+		if done, err := r.followWatch(ctx, accept); done || err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// followWatch follows the secret for the lifetime of one watch, reporting whether accept returned false.
+func (r *planSecretClient) followWatch(ctx context.Context, accept func(remotePlan) bool) (bool, error) {
+	w, err := r.client.CoreV1().Secrets(r.namespace).Watch(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("metadata.name", r.name).String(),
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, "could not watch plan secret %s/%s", r.namespace, r.name)
+	}
+	defer w.Stop()
+
+	// the secret is read once the watch is established, so that no change is missed in between
+	plan, err := r.read(ctx)
+	if err != nil {
+		return false, err
+	}
+	if !accept(plan) {
+		return true, nil
+	}
+
+	for event := range w.ResultChan() {
+		plan := remotePlan{}
+		switch event.Type {
+		case watch.Added, watch.Modified:
+			secret, ok := event.Object.(*corev1.Secret)
+			if !ok {
+				continue
+			}
+			if plan, err = parsePlanSecret(secret); err != nil {
+				return false, err
+			}
+		case watch.Deleted:
+		default:
+			continue
+		}
+		if !accept(plan) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func parsePlanSecret(secret *corev1.Secret) (remotePlan, error) {
+	p := remotePlan{
+		appliedChecksum: string(secret.Data[planSecretAppliedChecksumKey]),
+		urgent:          strings.ToLower(secret.Annotations[UrgentPlanAnnotation]) == "true",
+	}
+
+	raw := secret.Data[planSecretPlanKey]
+	if len(raw) == 0 {
+		return p, nil
+	}
+
+	cp, err := applyinator.CalculatePlan(raw)
+	if err != nil {
+		return remotePlan{}, errors.Wrap(err, "could not calculate plan from plan secret")
+	}
+	p.checksum = cp.Checksum
+	p.plan = cp.Plan
+	return p, nil
+}
+
+// remoteGate applies the plans that Rancher delivers in the plan secret in place of the system-agent plan watcher, so
+// that the maintenance windows and the plan policy are checked on the exact plan right before it is applied. Plans
+// that may not be applied yet stay in the plan secret until they may be, or Rancher replaces them.
+type remoteGate struct {
+	applier *applyinator.Applyinator
+	windows *MaintenanceWindows
+	policy  *planpolicy.Policy
+	plans   *planSecretClient
+	status  *statusReporter
+
+	// failed is the checksum of the plan that last failed to apply, at failedAt
+	failed   string
+	failedAt time.Time
+	// unreadable is whether the plan secret could not be read on the last sync, so that an unreachable Rancher is
+	// only reported when it becomes unreachable and when it is reachable again
+	unreadable bool
+}
+
+// remoteDecision is whether the plan of the plan secret may be applied.
+type remoteDecision struct {
+	apply  bool
+	status RemoteStatus
+}
+
+func (g *remoteGate) run(ctx context.Context) {
+	// wake is signaled whenever the plan secret changes, so that a new plan is inspected right away
+	wake := make(chan struct{}, 1)
+	go g.notify(ctx, wake)
+
+	var last RemoteStatus
+	for {
+		s := g.sync(ctx)
+		if s.QueuedPlanChecksum != "" && (s.Mode != last.Mode || s.QueuedPlanChecksum != last.QueuedPlanChecksum) {
+			switch s.Mode {
+			case RemoteModeDeferred:
+				logrus.Infof("Deferring remote plan %s: %s", s.QueuedPlanChecksum, s.Reason)
+			case RemoteModeBlocked:
+				logrus.Errorf("Blocked remote plan %s: %s: %s", s.QueuedPlanChecksum, s.Reason, strings.Join(s.Violations, "; "))
+			}
+		}
+		last = s
+		g.status.setRemote(s)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		case <-time.After(remoteGateInterval):
+		}
+	}
+}
+
+// notify signals wake whenever Rancher delivers another plan or marks it as urgent. Updates of the plan secret that
+// only record the outcome of a plan are ignored. The watch of the plan secret is established again if it fails.
+func (g *remoteGate) notify(ctx context.Context, wake chan<- struct{}) {
+	var last remotePlan
+	for ctx.Err() == nil {
+		err := g.plans.follow(ctx, func(plan remotePlan) bool {
+			if plan.checksum != last.checksum || plan.urgent != last.urgent {
+				select {
+				case wake <- struct{}{}:
+				default:
+				}
+			}
+			last = plan
+			return true
+		})
+		if err != nil && ctx.Err() == nil {
+			logrus.Debugf("Could not watch the remote plan: %v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(remoteGateInterval):
+			}
+		}
+	}
+}
+
+// sync reads the plan secret and applies its plan if it may be applied, returning the resulting status.
+func (g *remoteGate) sync(ctx context.Context) RemoteStatus {
+	secret, err := g.plans.get(ctx)
+	plan := remotePlan{}
+	if err == nil {
+		plan, err = parsePlanSecret(secret)
+	}
+	switch {
+	case err != nil && !g.unreadable:
+		logrus.Warnf("Could not determine the state of the remote plan: %v", err)
+	case err != nil:
+		logrus.Debugf("Could not determine the state of the remote plan: %v", err)
+	case g.unreadable:
+		logrus.Infof("The state of the remote plan can be determined again")
+	}
+	g.unreadable = err != nil
+
+	d := g.decide(time.Now(), plan, err == nil)
+	if d.apply {
+		if err := g.apply(ctx, secret, plan); err != nil {
+			logrus.Errorf("Error recording the outcome of remote plan %s: %v", plan.checksum, err)
+		}
+	}
+	return d.status
+}
+
+// decide determines whether the plan may be applied at now, known is false if the plan secret could not be read.
+// The plan policy is checked for plans that were applied before as well, as their periodic instructions keep running.
+func (g *remoteGate) decide(now time.Time, plan remotePlan, known bool) remoteDecision {
+	if !known {
+		return remoteDecision{status: RemoteStatus{Mode: RemoteModeBlocked, Reason: "the remote plan cannot be inspected"}}
+	}
+	if plan.checksum == "" {
+		return remoteDecision{status: RemoteStatus{Mode: RemoteModeIdle, Reason: "no remote plan has been delivered"}}
+	}
+
+	reason := "remote plans are not restricted"
+	if g.policy != nil {
+		if violations := g.policy.Evaluate(plan.plan); len(violations) > 0 {
+			s := RemoteStatus{Mode: RemoteModeBlocked, Reason: "plan violates the plan policy"}
+			if plan.pending() {
+				s.QueuedPlanChecksum = plan.checksum
+			}
+			for _, v := range violations {
+				s.Violations = append(s.Violations, v.String())
+			}
+			return remoteDecision{status: s}
+		}
+		reason = "plan " + plan.checksum + " complies with the plan policy"
+	}
+
+	if !plan.pending() {
+		return remoteDecision{apply: true, status: RemoteStatus{Mode: RemoteModeWatching, Reason: "plan " + plan.checksum + " is applied"}}
+	}
+
+	// a plan that is being applied when a window closes finishes, as it is applied before the next decision
+	if g.windows != nil {
+		open, windowReason := g.windowOpen(now, plan)
+		if !open {
+			s := RemoteStatus{Mode: RemoteModeDeferred, Reason: windowReason, QueuedPlanChecksum: plan.checksum}
+			if next := g.windows.NextOpen(now); !next.IsZero() {
+				s.NextWindow = &next
+			}
+			return remoteDecision{status: s}
+		}
+		reason = windowReason
+	}
+	return remoteDecision{apply: true, status: RemoteStatus{Mode: RemoteModeWatching, Reason: reason}}
+}
+
+// windowOpen decides whether remote plans may be applied at t, returning the reason for the decision.
+func (g *remoteGate) windowOpen(t time.Time, plan remotePlan) (bool, string) {
+	if g.windows.Open(t) {
+		return true, "inside maintenance window"
+	}
+	if plan.urgent {
+		return true, "plan secret is annotated with " + UrgentPlanAnnotation
+	}
+	if f := g.windows.OverrideFile; f != "" {
+		if _, err := os.Stat(f); err == nil {
+			return true, "override file " + f + " is present"
+		}
+	}
+	return false, "outside maintenance windows"
+}
+
+// apply applies the plan the same way the system-agent plan watcher does. The files and one time instructions of a
+// new plan are applied once, the periodic instructions and probes run on every sync. The outcome is recorded in the
+// plan secret, where Rancher reads it.
+func (g *remoteGate) apply(ctx context.Context, secret *corev1.Secret, plan remotePlan) error {
+	now := time.Now()
+	pending := plan.pending()
+	if pending && !g.retry(secret, plan, now) {
+		return nil
+	}
+
+	output, err := g.applier.Apply(ctx, applyinator.ApplyInput{
+		CalculatedPlan:         applyinator.CalculatedPlan{Plan: plan.plan, Checksum: plan.checksum},
+		ReconcileFiles:         pending,
+		RunOneTimeInstructions: pending,
+		ExistingOneTimeOutput:  secret.Data[planSecretAppliedOutputKey],
+		ExistingPeriodicOutput: secret.Data[planSecretAppliedPeriodicOutputKey],
+	})
+	if err != nil {
+		logrus.Errorf("Error applying remote plan %s: %v", plan.checksum, err)
+	}
+
+	data := map[string][]byte{}
+	var remove []string
+	if err == nil {
+		data[planSecretAppliedPeriodicOutputKey] = output.PeriodicOutput
+	}
+	if pending {
+		if err == nil && output.OneTimeApplySucceeded {
+			logrus.Infof("Applied remote plan %s", plan.checksum)
+			data[planSecretAppliedChecksumKey] = []byte(plan.checksum)
+			data[planSecretAppliedOutputKey] = output.OneTimeOutput
+			remove = []string{planSecretFailedChecksumKey, planSecretFailedOutputKey, planSecretFailureCountKey}
+		} else {
+			failures := 1
+			if string(secret.Data[planSecretFailedChecksumKey]) == plan.checksum {
+				failures = failureCount(secret) + 1
+			}
+			logrus.Errorf("Failed to apply remote plan %s, %d failures", plan.checksum, failures)
+			data[planSecretFailedChecksumKey] = []byte(plan.checksum)
+			data[planSecretFailedOutputKey] = output.OneTimeOutput
+			data[planSecretFailureCountKey] = []byte(strconv.Itoa(failures))
+			g.failed, g.failedAt = plan.checksum, now
+		}
+	}
+
+	statuses, err := json.Marshal(runProbes(secret, plan, pending))
+	if err != nil {
+		return errors.Wrap(err, "could not marshal probe statuses")
+	}
+	data[planSecretProbeStatusesKey] = statuses
+	return g.plans.record(ctx, data, remove)
+}
+
+// retry reports whether a pending plan is applied, plans that failed before are retried after a cooldown until the
+// max-failures set by Rancher are reached.
+func (g *remoteGate) retry(secret *corev1.Secret, plan remotePlan, now time.Time) bool {
+	if string(secret.Data[planSecretFailedChecksumKey]) != plan.checksum {
+		return true
+	}
+	if limit, err := strconv.Atoi(string(secret.Data[planSecretMaxFailuresKey])); err == nil && limit > 0 && failureCount(secret) >= limit {
+		return false
+	}
+	return g.failed != plan.checksum || now.Sub(g.failedAt) >= remotePlanRetryCooldown
+}
+
+func failureCount(secret *corev1.Secret) int {
+	n, _ := strconv.Atoi(string(secret.Data[planSecretFailureCountKey]))
+	return n
+}
+
+// runProbes runs the probes of the plan concurrently. They continue from the statuses recorded in the plan secret,
+// unless the plan was just applied.
+func runProbes(secret *corev1.Secret, plan remotePlan, initial bool) map[string]prober.ProbeStatus {
+	previous := map[string]prober.ProbeStatus{}
+	if raw := secret.Data[planSecretProbeStatusesKey]; !initial && len(raw) != 0 {
+		if err := json.Unmarshal(raw, &previous); err != nil {
+			logrus.Warnf("Could not parse the probe statuses of remote plan %s: %v", plan.checksum, err)
+		}
+	}
+
+	statuses := map[string]prober.ProbeStatus{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, probe := range plan.plan.Probes {
+		wg.Add(1)
+		go func(name string, probe prober.Probe, status prober.ProbeStatus) {
+			defer wg.Done()
+			if err := prober.DoProbe(probe, &status, initial); err != nil {
+				logrus.Debugf("Error running probe %s of remote plan %s: %v", name, plan.checksum, err)
+			}
+			mu.Lock()
+			statuses[name] = status
+			mu.Unlock()
+		}(name, probe, previous[name])
+	}
+	wg.Wait()
+	return statuses
+}
@@ -0,0 +1,180 @@
+package systemagent
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rancher/system-agent/pkg/applyinator"
+	"github.com/rancher/wins/pkg/planpolicy"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRemoteGateDecide(t *testing.T) {
+	windows := &MaintenanceWindows{Timezone: "UTC", Windows: []MaintenanceWindow{{Start: "01:00", End: "05:00"}}}
+	if err := windows.validate(); err != nil {
+		t.Fatal(err)
+	}
+	policy := &planpolicy.Policy{AllowedCommands: []planpolicy.AllowedCommand{{Command: "powershell.exe"}}}
+	if err := policy.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	inside := time.Date(2026, time.October, 20, 3, 0, 0, 0, time.UTC)
+	outside := time.Date(2026, time.October, 20, 12, 0, 0, 0, time.UTC)
+	compliant := remotePlan{checksum: "a", plan: applyinator.Plan{OneTimeInstructions: []applyinator.OneTimeInstruction{
+		{CommonInstruction: applyinator.CommonInstruction{Name: "ok", Command: "powershell.exe"}},
+	}}}
+	violating := remotePlan{checksum: "b", plan: applyinator.Plan{OneTimeInstructions: []applyinator.OneTimeInstruction{
+		{CommonInstruction: applyinator.CommonInstruction{Name: "bad", Command: "cmd.exe"}},
+	}}}
+	applied := remotePlan{checksum: "c", appliedChecksum: "c"}
+	urgent := compliant
+	urgent.urgent = true
+
+	type test struct {
+		name    string
+		windows *MaintenanceWindows
+		policy  *planpolicy.Policy
+		at      time.Time
+		plan    remotePlan
+		known   bool
+		apply   bool
+		mode    string
+	}
+
+	tests := []test{
+		{name: "Inside window", windows: windows, at: inside, plan: compliant, known: true, apply: true, mode: RemoteModeWatching},
+		{name: "Outside window", windows: windows, at: outside, plan: compliant, known: true, mode: RemoteModeDeferred},
+		{name: "Outside window with urgent plan", windows: windows, at: outside, plan: urgent, known: true, apply: true, mode: RemoteModeWatching},
+		{name: "Applied plan outside window", windows: windows, at: outside, plan: applied, known: true, apply: true, mode: RemoteModeWatching},
+		{name: "Violating plan", policy: policy, at: inside, plan: violating, known: true, mode: RemoteModeBlocked},
+		{name: "Compliant plan", policy: policy, at: outside, plan: compliant, known: true, apply: true, mode: RemoteModeWatching},
+		{name: "Unreadable plan secret", policy: policy, at: outside, known: false, mode: RemoteModeBlocked},
+		{name: "No plan delivered", policy: policy, at: outside, known: true, mode: RemoteModeIdle},
+		{name: "Violating plan outside window", windows: windows, policy: policy, at: outside, plan: violating, known: true, mode: RemoteModeBlocked},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			g := &remoteGate{windows: tst.windows, policy: tst.policy}
+			d := g.decide(tst.at, tst.plan, tst.known)
+			if d.apply != tst.apply || d.status.Mode != tst.mode {
+				t.Errorf("expected apply %t in mode %s, got apply %t in mode %s (%s)",
+					tst.apply, tst.mode, d.apply, d.status.Mode, d.status.Reason)
+			}
+		})
+	}
+}
+
+func TestRemoteGateSync(t *testing.T) {
+	policy := &planpolicy.Policy{AllowedCommands: []planpolicy.AllowedCommand{{Command: "powershell.exe"}}}
+	if err := policy.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "cattle-system", Name: "plan"},
+		Data:       map[string][]byte{planSecretPlanKey: []byte(`{"instructions":[{"name":"bad","command":"cmd.exe"}]}`)},
+	}
+	client := fake.NewClientset(secret)
+	root := t.TempDir()
+	g := &remoteGate{
+		applier: applyinator.NewApplyinator(filepath.Join(root, "work"), false, filepath.Join(root, "applied"), "", nil),
+		policy:  policy,
+		plans:   &planSecretClient{client: client, namespace: secret.Namespace, name: secret.Name},
+		status:  newStatusReporter(""),
+	}
+
+	// a violating plan is never applied
+	if s := g.sync(context.Background()); s.Mode != RemoteModeBlocked || s.QueuedPlanChecksum == "" {
+		t.Errorf("expected the plan to be blocked, got %+v", s)
+	}
+	if applied := getPlanSecret(t, client, secret).Data[planSecretAppliedChecksumKey]; len(applied) != 0 {
+		t.Errorf("expected the violating plan not to be applied, got applied checksum %s", applied)
+	}
+
+	// a compliant plan is applied and recorded in the plan secret
+	secret.Data[planSecretPlanKey] = []byte(`{"instructions":[]}`)
+	if _, err := client.CoreV1().Secrets(secret.Namespace).Update(context.Background(), secret, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	compliant, err := g.plans.read(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := g.sync(context.Background()); s.Mode != RemoteModeWatching {
+		t.Errorf("expected the plan to be applied, got %+v", s)
+	}
+	if applied := string(getPlanSecret(t, client, secret).Data[planSecretAppliedChecksumKey]); applied != compliant.checksum {
+		t.Errorf("expected plan %s to be recorded as applied, got %s", compliant.checksum, applied)
+	}
+
+	// a plan secret that cannot be read is reported once until it can be read again
+	if err := client.CoreV1().Secrets(secret.Namespace).Delete(context.Background(), secret.Name, metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if s := g.sync(context.Background()); s.Mode != RemoteModeBlocked || !g.unreadable {
+			t.Errorf("expected the plan secret to be unreadable, got %+v", s)
+		}
+	}
+	secret.ResourceVersion = ""
+	if _, err := client.CoreV1().Secrets(secret.Namespace).Create(context.Background(), secret, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if s := g.sync(context.Background()); s.Mode != RemoteModeWatching || g.unreadable {
+		t.Errorf("expected the plan secret to be readable again, got %+v", s)
+	}
+}
+
+func TestRemoteGateNotify(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "cattle-system", Name: "plan"},
+		Data:       map[string][]byte{planSecretPlanKey: []byte(`{"instructions":[]}`)},
+	}
+	client := fake.NewClientset(secret)
+	g := &remoteGate{plans: &planSecretClient{client: client, namespace: secret.Namespace, name: secret.Name}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wake := make(chan struct{}, 1)
+	go g.notify(ctx, wake)
+	select {
+	case <-wake:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the gate to be woken for the current plan")
+	}
+
+	// recording the outcome of the plan does not wake the gate
+	secret.Data[planSecretAppliedChecksumKey] = []byte("applied")
+	if _, err := client.CoreV1().Secrets(secret.Namespace).Update(context.Background(), secret, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-wake:
+		t.Fatal("expected the gate not to be woken when only the outcome of the plan changed")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// but another plan does
+	secret.Data[planSecretPlanKey] = []byte(`{"instructions":[{"name":"new","command":"cmd.exe"}]}`)
+	if _, err := client.CoreV1().Secrets(secret.Namespace).Update(context.Background(), secret, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-wake:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the gate to be woken when the plan changed")
+	}
+}
+
+func getPlanSecret(t *testing.T, client *fake.Clientset, secret *corev1.Secret) *corev1.Secret {
+	s, err := client.CoreV1().Secrets(secret.Namespace).Get(context.Background(), secret.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
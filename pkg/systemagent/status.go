@@ -0,0 +1,161 @@
+package systemagent
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	RemoteModeDisabled = "disabled"
+	RemoteModeWatching = "watching"
+	RemoteModeDeferred = "deferred"
+	RemoteModeBlocked  = "blocked"
+	RemoteModeIdle     = "idle"
+)
+
+// Status is the wins view of the embedded system-agent. It is written to disk as JSON
+// so that operators can see why a plan has not been applied yet.
+type Status struct {
+	Remote          RemoteStatus           `json:"remote"`
+	OfflineFallback *OfflineFallbackStatus `json:"offlineFallback,omitempty"`
+	LocalSources    []LocalSourceStatus    `json:"localSources,omitempty"`
+}
+
+// RemoteStatus describes the handling of plans received from Rancher.
+type RemoteStatus struct {
+	Mode string `json:"mode"`
+	// Reason explains why the remote watch is in its current mode.
+	Reason string `json:"reason,omitempty"`
+	// QueuedPlanChecksum is the checksum of a plan received from Rancher that has not been applied yet.
+	QueuedPlanChecksum string `json:"queuedPlanChecksum,omitempty"`
+	// Violations lists the plan policy rules the queued plan breaks.
+	Violations []string `json:"violations,omitempty"`
+	// NextWindow is when the next maintenance window opens, only set while plans are deferred.
+	NextWindow *time.Time `json:"nextWindow,omitempty"`
+	// LastTransition is when the mode last changed.
+	LastTransition time.Time `json:"lastTransition"`
+}
+
+// OfflineFallbackStatus describes whether local plans are used because Rancher is unreachable.
+type OfflineFallbackStatus struct {
+	// Engaged is true while the fallback local plan source is enabled.
+	Engaged bool   `json:"engaged"`
+	Source  string `json:"source"`
+	// UnreachableSince is when the API server was first found unreachable, only set while it is unreachable.
+	UnreachableSince *time.Time `json:"unreachableSince,omitempty"`
+	// LastError is the error of the last failed connectivity probe.
+	LastError string `json:"lastError,omitempty"`
+	// LastTransition is when the fallback was last engaged or disengaged.
+	LastTransition time.Time `json:"lastTransition"`
+}
+
+// LocalSourceStatus describes the plans wins has staged from a local plan source.
+type LocalSourceStatus struct {
+	Name     string         `json:"name"`
+	Enabled  bool           `json:"enabled"`
+	Priority int            `json:"priority"`
+	Staged   []string       `json:"staged,omitempty"`
+	Rejected []RejectedPlan `json:"rejected,omitempty"`
+}
+
+// RejectedPlan is a plan that wins refused to hand to the system-agent.
+type RejectedPlan struct {
+	File   string `json:"file"`
+	Reason string `json:"reason"`
+}
+
+// statusReporter keeps the current Status and persists every change of it.
+type statusReporter struct {
+	mu     sync.Mutex
+	path   string
+	status Status
+}
+
+func newStatusReporter(path string) *statusReporter {
+	return &statusReporter{path: path}
+}
+
+// setRemote records the remote status, the transition time is only updated when the mode changes.
+func (r *statusReporter) setRemote(s RemoteStatus) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if s.Mode != r.status.Remote.Mode {
+		s.LastTransition = time.Now()
+	} else {
+		s.LastTransition = r.status.Remote.LastTransition
+	}
+	if reflect.DeepEqual(r.status.Remote, s) {
+		return
+	}
+	r.status.Remote = s
+	r.flush()
+}
+
+// setOfflineFallback records the offline fallback status, the transition time is only updated when it is engaged or disengaged.
+func (r *statusReporter) setOfflineFallback(s OfflineFallbackStatus) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.status.OfflineFallback == nil || s.Engaged != r.status.OfflineFallback.Engaged {
+		s.LastTransition = time.Now()
+	} else {
+		s.LastTransition = r.status.OfflineFallback.LastTransition
+	}
+	if reflect.DeepEqual(r.status.OfflineFallback, &s) {
+		return
+	}
+	r.status.OfflineFallback = &s
+	r.flush()
+}
+
+// setLocalSource records the status of a local plan source, sources are kept in order of priority.
+func (r *statusReporter) setLocalSource(s LocalSourceStatus) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.status.LocalSources {
+		if r.status.LocalSources[i].Name == s.Name {
+			if reflect.DeepEqual(r.status.LocalSources[i], s) {
+				return
+			}
+			r.status.LocalSources[i] = s
+			r.flush()
+			return
+		}
+	}
+	r.status.LocalSources = append(r.status.LocalSources, s)
+	sort.SliceStable(r.status.LocalSources, func(i, j int) bool {
+		return r.status.LocalSources[i].Priority > r.status.LocalSources[j].Priority
+	})
+	r.flush()
+}
+
+// flush writes the status through a temporary file, so readers never observe a partial document.
+func (r *statusReporter) flush() {
+	if r.path == "" {
+		return
+	}
+
+	bs, err := json.MarshalIndent(r.status, "", "  ")
+	if err != nil {
+		logrus.Warnf("could not marshal system-agent status: %v", err)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), os.ModePerm); err != nil {
+		logrus.Warnf("could not create directory for system-agent status file %s: %v", r.path, err)
+		return
+	}
+
+	if err := writeFileAtomic(r.path, bs); err != nil {
+		logrus.Warnf("could not write system-agent status file %s: %v", r.path, err)
+	}
+}
@@ -1,6 +1,7 @@
 package tls
 
 import (
+	"crypto/tls"
 	"crypto/x509"
 	"fmt"
 	"os"
@@ -28,11 +29,6 @@ func (c *Config) SetupGenericTLSConfigFromFile() (*x509.CertPool, error) {
 		systemCerts = x509.NewCertPool()
 	}
 
-	localCertPath, err := os.Stat(c.CertFilePath)
-	if err != nil {
-		return nil, fmt.Errorf("[SetupGenericTLSConfigFromFile] unable to read certificate %s from %s: %v", localCertPath.Name(), c.CertFilePath, err)
-	}
-
 	certs, err := os.ReadFile(c.CertFilePath)
 	if err != nil {
 		return nil, fmt.Errorf("[SetupGenericTLSConfigFromFile] failed to read local cert file %q: %v", c.CertFilePath, err)
@@ -46,3 +42,22 @@ func (c *Config) SetupGenericTLSConfigFromFile() (*x509.CertPool, error) {
 	logrus.Infof("[SetupGenericTLSConfigFromFile] successfully loaded %s certificate into system cert store", c.CertFilePath)
 	return systemCerts, nil
 }
+
+// ClientConfig returns the TLS config for HTTPS clients of wins. Server certificates are verified against the
+// system certificate store and the certificate file, unless insecure is explicitly set to true.
+func (c *Config) ClientConfig() (*tls.Config, error) {
+	if c == nil {
+		return &tls.Config{}, nil
+	}
+	if c.Insecure != nil && *c.Insecure {
+		logrus.Warn("TLS certificate verification is disabled by the insecure setting")
+		return &tls.Config{InsecureSkipVerify: true}, nil
+	}
+
+	pool, err := c.SetupGenericTLSConfigFromFile()
+	if err != nil {
+		return nil, err
+	}
+	// a nil pool uses the system certificate store
+	return &tls.Config{RootCAs: pool}, nil
+}
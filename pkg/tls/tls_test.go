@@ -0,0 +1,67 @@
+package tls
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClientConfig(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("csi-proxy"))
+	}))
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	insecure, secure := true, false
+
+	type test struct {
+		name        string
+		cfg         *Config
+		errExpected bool
+	}
+
+	tests := []test{
+		{name: "Custom CA", cfg: &Config{Insecure: &secure, CertFilePath: caFile}},
+		{name: "Custom CA verifies by default", cfg: &Config{CertFilePath: caFile}},
+		{name: "Explicitly insecure", cfg: &Config{Insecure: &insecure}},
+		{name: "No config verifies against the system store", cfg: nil, errExpected: true},
+		{name: "Secure without CA", cfg: &Config{Insecure: &secure}, errExpected: true},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			tlsConfig, err := tst.cfg.ClientConfig()
+			if err != nil {
+				t.Fatalf("unexpected error creating the TLS config: %v", err)
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+
+			resp, err := client.Get(srv.URL)
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+			if err != nil && !tst.errExpected {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if err == nil && tst.errExpected {
+				t.Error("expected a certificate verification error")
+			}
+		})
+	}
+}
+
+func TestClientConfigMissingCertFile(t *testing.T) {
+	cfg := &Config{CertFilePath: filepath.Join(t.TempDir(), "missing.pem")}
+	if _, err := cfg.ClientConfig(); err == nil {
+		t.Error("expected an error for a missing certificate file")
+	}
+}
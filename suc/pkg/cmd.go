@@ -14,6 +14,14 @@
 )
 
 func Run(_ *cli.Context) error {
+	hook, err := config.LogFileHook()
+	if err != nil {
+		logrus.Warnf("Could not open the log file: %v", err)
+	} else if hook != nil {
+		defer hook.Close()
+		logrus.AddHook(hook)
+	}
+
 	var errs []error
 	initialState, err := state.BuildInitialState()
 	if err != nil {
@@ -45,9 +53,11 @@ func Run(_ *cli.Context) error {
 		errs = append(errs, err)
 	}
 
-	err = service.ConfigureRKE2ServiceDependency()
+	deps, err := config.UpdateServiceDependenciesFromEnvVars()
 	if err != nil {
 		errs = append(errs, err)
+	} else if err = service.ConfigureServiceDependencies(deps); err != nil {
+		errs = append(errs, err)
 	}
 
 	restartServiceDueToBinaryUpgrade, err := host.UpgradeRancherWinsBinary()
@@ -86,7 +86,9 @@ func updateBinaries(desiredVersion string) (bool, error) {
 
 	if rwExists {
 		// The service needs to be stopped before we can modify the binary it uses
-		err = rw.Stop()
+		ctx, cancel := service.TransitionContext()
+		err = rw.Stop(ctx)
+		cancel()
 		if err != nil {
 			return false, fmt.Errorf("failed to stop rancher-wins service while attempting to upgrade binary: %w", err)
 		}
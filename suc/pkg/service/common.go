@@ -1,38 +1,35 @@
 package service
 
 import (
+	"context"
 	"os"
 	"strconv"
 	"time"
 
 	"github.com/sirupsen/logrus"
-	"golang.org/x/sys/windows/svc"
 )
 
-func getStateTransitionAttempts() int {
-	env := os.Getenv("CATTLE_WINS_STATE_TRANSITION_ATTEMPTS")
-	if env != "" {
-		i, err := strconv.Atoi(env)
-		if err != nil {
-			logrus.Debugf("failed to cast 'CATTLE_WINDOWS_STATE_TRANSITION_ATTEMPTS' (%s) to an integer, returning default value of %d", env, stateTransitionAttempts)
-			return stateTransitionAttempts
-		}
-		return i
-	}
-	return stateTransitionAttempts
+// TransitionContext returns a context for waiting on a service state transition. The services are queried with
+// a backoff until the context expires after CATTLE_WINS_STATE_TRANSITION_ATTEMPTS times
+// CATTLE_WINS_STATE_TRANSITION_SECONDS, which default to 12 and 5.
+func TransitionContext() (context.Context, context.CancelFunc) {
+	timeout := time.Duration(envInt("CATTLE_WINS_STATE_TRANSITION_ATTEMPTS", stateTransitionAttempts)) *
+		time.Duration(envInt("CATTLE_WINS_STATE_TRANSITION_SECONDS", stateTransitionDelayInSeconds)) * time.Second
+	return context.WithTimeout(context.Background(), timeout)
 }
 
-func getStateTransitionDelayInSeconds() time.Duration {
-	env := os.Getenv("CATTLE_WINS_STATE_TRANSITION_SECONDS")
-	if env != "" {
-		i, err := strconv.Atoi(env)
-		if err != nil {
-			logrus.Debugf("failed to cast 'CATTLE_WINDOWS_STATE_TRANSITION_SECONDS' (%s) to an integer, returning default value of %d", env, stateTransitionDelayInSeconds)
-			return stateTransitionDelayInSeconds
-		}
-		return time.Duration(i)
+// envInt returns the positive integer value of the environment variable, or the default value if it is unset or invalid.
+func envInt(name string, defaultValue int) int {
+	env := os.Getenv(name)
+	if env == "" {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(env)
+	if err != nil || i <= 0 {
+		logrus.Debugf("failed to cast '%s' (%s) to a positive integer, returning default value of %d", name, env, defaultValue)
+		return defaultValue
 	}
-	return stateTransitionDelayInSeconds
+	return i
 }
 
 func UnorderedSlicesEqual[T comparable](s1 []T, s2 []T) bool {
@@ -64,19 +61,3 @@ func removeAllFromSlice[T comparable](x T, s []T) []T {
 	}
 	return n
 }
-
-// serviceStateToString translates a svc.State to its string representation
-func serviceStateToString(state svc.State) string {
-	switch state {
-	case svc.Running:
-		return "Running"
-	case svc.Stopped:
-		return "Stopped"
-	case svc.StopPending:
-		return "Stop Pending"
-	case svc.StartPending:
-		return "Start Pending"
-	default:
-		return "Unknown State"
-	}
-}
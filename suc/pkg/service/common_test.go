@@ -1,6 +1,9 @@
 package service
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func Test_UnorderedSlicesEqual(t *testing.T) {
 	type test struct {
@@ -63,3 +66,15 @@ type test struct {
 	}
 
 }
+
+func TestTransitionContext(t *testing.T) {
+	t.Setenv("CATTLE_WINS_STATE_TRANSITION_ATTEMPTS", "3")
+	t.Setenv("CATTLE_WINS_STATE_TRANSITION_SECONDS", "invalid")
+
+	ctx, cancel := TransitionContext()
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	if remaining := time.Until(deadline); !ok || remaining <= 14*time.Second || remaining > 15*time.Second {
+		t.Errorf("expected the context to expire after 15s, got %s", remaining)
+	}
+}
@@ -6,6 +6,9 @@
 	"strings"
 
 	"github.com/rancher/wins/cmd/server/config"
+	"github.com/rancher/wins/pkg/defaults"
+	"github.com/rancher/wins/pkg/logs"
+	"github.com/rancher/wins/pkg/services"
 	"github.com/sirupsen/logrus"
 )
 
@@ -13,6 +16,9 @@
 	DirEnvVar            = "CATTLE_WINS_CONFIG_DIR"
 	DebugEnvVar          = "CATTLE_WINS_DEBUG"
 	AgentStringTLSEnvVar = "STRICT_VERIFY"
+	// ServiceDependencyEnvVar declares the dependency of rke2 on rancher-wins if it is true, and removes it if it is
+	// set to any other value.
+	ServiceDependencyEnvVar = "CATTLE_ENABLE_WINS_SERVICE_DEPENDENCY"
 )
 
 const (
@@ -49,6 +55,20 @@ func SaveConfig(cfg *config.Config, path string) error {
 	return config.SaveConfig(getConfigPath(path), cfg)
 }
 
+// LogFileHook returns a logrus hook that writes to the log file of the SUC, which is configured in the rancher-wins
+// config file. LogFileHook returns nil if no log file is configured.
+func LogFileHook() (*logs.FileHook, error) {
+	cfg, err := LoadConfig("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %v", err)
+	}
+	logFile := cfg.LogFileOf(defaults.WindowsSUCName)
+	if logFile == nil {
+		return nil, nil
+	}
+	return logs.NewFileHook(*logFile)
+}
+
 // UpdateConfigFromEnvVars is responsible for updating the rancher-wins config file
 // based off of the presence of particular environment variables. The path parameter is used
 // to specify the location of the config file on the host. If path is left empty, the defaultConfigFile
@@ -97,3 +117,71 @@ func UpdateConfigFromEnvVars() (bool, error) {
 
 	return configNeedsUpdate, nil
 }
+
+// UpdateServiceDependenciesFromEnvVars declares the dependency of rke2 on rancher-wins in the rancher-wins config file
+// if the ServiceDependencyEnvVar is true, and removes it if the variable is set to any other value. If the variable
+// is not set, the declared dependencies are left as they are. The config file is only updated if the declared
+// dependencies change. UpdateServiceDependenciesFromEnvVars returns the service dependencies of the config file.
+func UpdateServiceDependenciesFromEnvVars() ([]services.Dependency, error) {
+	path := getConfigPath("")
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %v", err)
+	}
+
+	v, ok := os.LookupEnv(ServiceDependencyEnvVar)
+	if !ok {
+		logrus.Infof("%s is not set, leaving the rke2 service dependency on %s in the config file as it is", ServiceDependencyEnvVar, defaults.WindowsServiceName)
+		return cfg.ServiceDependencies, nil
+	}
+	logrus.Infof("Found value '%s' for %s", v, ServiceDependencyEnvVar)
+	add := strings.ToLower(v) == "true"
+	deps, changed := setDependency(cfg.ServiceDependencies, "rke2", defaults.WindowsServiceName, add)
+	if !changed {
+		logrus.Infof("rke2 service dependency on %s already set to %t in the config file", defaults.WindowsServiceName, add)
+		return deps, nil
+	}
+
+	logrus.Infof("Updating the rke2 service dependency on %s in the config file to %t", defaults.WindowsServiceName, add)
+	cfg.ServiceDependencies = deps
+	if err := SaveConfig(cfg, path); err != nil {
+		return nil, fmt.Errorf("failed to save config: %w", err)
+	}
+	return deps, nil
+}
+
+// setDependency adds or removes the dependency of service on dependsOn, reporting whether the dependencies changed.
+func setDependency(deps []services.Dependency, service, dependsOn string, enabled bool) ([]services.Dependency, bool) {
+	var updated []services.Dependency
+	found, changed := false, false
+	for _, d := range deps {
+		if !strings.EqualFold(d.Service, service) {
+			updated = append(updated, d)
+			continue
+		}
+		var kept []string
+		for _, dep := range d.DependsOn {
+			if !strings.EqualFold(dep, dependsOn) {
+				kept = append(kept, dep)
+				continue
+			}
+			found = true
+			if enabled {
+				kept = append(kept, dep)
+			} else {
+				changed = true
+			}
+		}
+		if len(kept) > 0 {
+			updated = append(updated, services.Dependency{Service: d.Service, DependsOn: kept})
+		}
+	}
+	if enabled && !found {
+		updated = append(updated, services.Dependency{Service: service, DependsOn: []string{dependsOn}})
+		changed = true
+	}
+	if !changed {
+		return deps, false
+	}
+	return updated, true
+}
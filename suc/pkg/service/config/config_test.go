@@ -12,6 +12,7 @@
 
 	"github.com/pkg/errors"
 	"github.com/rancher/wins/cmd/server/config"
+	"github.com/rancher/wins/pkg/services"
 	v1 "k8s.io/api/core/v1"
 )
 
@@ -157,3 +158,83 @@ type test struct {
 		})
 	}
 }
+
+func Test_setDependency(t *testing.T) {
+	declared := []services.Dependency{
+		{Service: "csiproxy", DependsOn: []string{"containerd"}},
+		{Service: "RKE2", DependsOn: []string{"other", "Rancher-Wins"}},
+	}
+
+	deps, changed := setDependency(declared, "rke2", "rancher-wins", true)
+	if changed || !reflect.DeepEqual(deps, declared) {
+		t.Errorf("expected the declared dependency to be kept, got %v", deps)
+	}
+
+	deps, changed = setDependency(declared, "rke2", "rancher-wins", false)
+	expected := []services.Dependency{
+		{Service: "csiproxy", DependsOn: []string{"containerd"}},
+		{Service: "RKE2", DependsOn: []string{"other"}},
+	}
+	if !changed || !reflect.DeepEqual(deps, expected) {
+		t.Errorf("expected %v, got %v", expected, deps)
+	}
+
+	deps, changed = setDependency(nil, "rke2", "rancher-wins", true)
+	expected = []services.Dependency{{Service: "rke2", DependsOn: []string{"rancher-wins"}}}
+	if !changed || !reflect.DeepEqual(deps, expected) {
+		t.Errorf("expected %v, got %v", expected, deps)
+	}
+}
+
+func Test_UpdateServiceDependenciesFromEnvVars(t *testing.T) {
+	declared := []services.Dependency{{Service: "rke2", DependsOn: []string{"rancher-wins"}}}
+
+	type test struct {
+		name     string
+		envVars  []v1.EnvVar
+		expected []services.Dependency
+	}
+
+	tests := []test{
+		{
+			name:     "Unset variable keeps the dependency",
+			expected: declared,
+		},
+		{
+			name:    "Disabled dependency is removed",
+			envVars: []v1.EnvVar{{Name: ServiceDependencyEnvVar, Value: "false"}},
+		},
+		{
+			name:     "Enabled dependency is kept",
+			envVars:  []v1.EnvVar{{Name: ServiceDependencyEnvVar, Value: "true"}},
+			expected: declared,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			setupTest(tc.envVars, t)
+			cfg := config.DefaultConfig()
+			cfg.ServiceDependencies = declared
+			if err := SaveConfig(cfg, configFileLoc); err != nil {
+				t.Fatalf("failed to save the config: %v", err)
+			}
+
+			deps, err := UpdateServiceDependenciesFromEnvVars()
+			if err != nil {
+				t.Fatalf("UpdateServiceDependenciesFromEnvVars returned an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(deps, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, deps)
+			}
+
+			updatedConfig := config.DefaultConfig()
+			if err := config.LoadConfig(configFileLoc, updatedConfig); err != nil {
+				t.Fatalf("encountered an error when reloading the config file: %v", err)
+			}
+			if !reflect.DeepEqual(updatedConfig.ServiceDependencies, tc.expected) {
+				t.Errorf("expected the config file to declare %v, got %v", tc.expected, updatedConfig.ServiceDependencies)
+			}
+		})
+	}
+}
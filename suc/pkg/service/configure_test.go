@@ -0,0 +1,69 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rancher/wins/pkg/defaults"
+	"github.com/rancher/wins/pkg/scm"
+	"github.com/rancher/wins/pkg/services"
+)
+
+func TestRefreshWinsService(t *testing.T) {
+	fake := scm.NewFake()
+	fake.Add(defaults.WindowsServiceName, scm.Config{}, scm.Running)
+	fake.Add("rke2", scm.Config{Dependencies: []string{"other", defaults.WindowsServiceName}}, scm.Running)
+	fake.Add("other", scm.Config{}, scm.Running)
+
+	previous := manager
+	manager = fake
+	defer func() {
+		manager = previous
+	}()
+
+	if err := RefreshWinsService(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	wins, _ := fake.Service(defaults.WindowsServiceName)
+	if wins.Starts != 1 || wins.Status.State != scm.Running {
+		t.Errorf("expected %s to be restarted, got %d starts and state %s", defaults.WindowsServiceName, wins.Starts, wins.Status.State)
+	}
+	rke2, _ := fake.Service("rke2")
+	if !reflect.DeepEqual(rke2.Config.Dependencies, []string{"other", defaults.WindowsServiceName}) {
+		t.Errorf("expected the rke2 dependency to be restored, got %v", rke2.Config.Dependencies)
+	}
+	if rke2.Status.State != scm.Running {
+		t.Errorf("expected rke2 to keep running, got state %s", rke2.Status.State)
+	}
+}
+
+func TestConfigureServiceDependencies(t *testing.T) {
+	fake := scm.NewFake()
+	fake.Add(defaults.WindowsServiceName, scm.Config{}, scm.Running)
+	fake.Add("rke2", scm.Config{Dependencies: []string{defaults.WindowsServiceName}}, scm.Running)
+
+	previous := manager
+	manager = fake
+	defer func() {
+		manager = previous
+	}()
+
+	deps := []services.Dependency{{Service: "rke2", DependsOn: []string{defaults.WindowsServiceName}}}
+	if err := ConfigureServiceDependencies(deps); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rke2, _ := fake.Service("rke2")
+	if !reflect.DeepEqual(rke2.Config.Dependencies, []string{defaults.WindowsServiceName}) {
+		t.Errorf("expected rke2 to depend on %s, got %v", defaults.WindowsServiceName, rke2.Config.Dependencies)
+	}
+
+	// the dependency is removed once it is not declared, even if it was configured by a previous version
+	fake.Add("rke2", scm.Config{Dependencies: []string{defaults.WindowsServiceName}}, scm.Running)
+	if err := ConfigureServiceDependencies(nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rke2, _ = fake.Service("rke2"); len(rke2.Config.Dependencies) != 0 {
+		t.Errorf("expected the rke2 dependency to be removed, got %v", rke2.Config.Dependencies)
+	}
+}
@@ -40,17 +40,8 @@ func (rke2 *RKE2Service) HasRancherWinsServiceDependency() (bool, error) {
 	return false, nil
 }
 
-func (rke2 *RKE2Service) AddRancherWinsServiceDependency() error {
-	rke2.Config.Dependencies = append(rke2.Config.Dependencies, defaults.WindowsServiceName)
-	return rke2.UpdateConfig()
-}
-
 func (rke2 *RKE2Service) RemoveRancherWinsServiceDependency() error {
+	// the service manager clears the dependencies when the remaining list is empty
 	rke2.Config.Dependencies = removeAllFromSlice(defaults.WindowsServiceName, rke2.Config.Dependencies)
-	if len(rke2.Config.Dependencies) == 0 {
-		// Updating a service config with a nil or empty Dependencies slice will not have any effect.
-		// Instead, '/' must be used to clear any remaining service dependencies.
-		rke2.Config.Dependencies = append(rke2.Config.Dependencies, "/")
-	}
 	return rke2.UpdateConfig()
 }